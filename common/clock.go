@@ -0,0 +1,245 @@
+package common
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Definitions for telling time.  Services get the time and set timers through a Clock, so that a simulated clock can
+// be injected to run simulations faster than real time, and to make tests deterministic.
+
+// Clock provides the subset of the time package used by the services.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+// Timer mirrors time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker mirrors time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// The clock used to time stamp diagnostic messages.
+var DiagClock Clock = RealClock{}
+
+// RealClock tells the wall clock time.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time                         { return time.Now() }
+func (RealClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (RealClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+func (RealClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (RealClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// SimClock is a simulated clock.  Time stands still until it is advanced, at which point the timers and tickers
+// that became due fire in deadline order, each seeing the time set to its own deadline.
+type SimClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters waiterHeap
+	// Incremented for every new waiter, so that waiters with equal deadlines fire in creation order.
+	seq int
+	// Signalled whenever a waiter is added, for BlockUntil.
+	added *sync.Cond
+}
+
+func NewSimClock(start time.Time) *SimClock {
+	c := &SimClock{now: start}
+	c.added = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *SimClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *SimClock) NewTimer(d time.Duration) Timer {
+	t := &simWaiter{clock: c, ch: make(chan time.Time, 1)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schedule(t, c.now.Add(d))
+	return t
+}
+
+func (c *SimClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for SimClock.NewTicker")
+	}
+	t := &simWaiter{clock: c, ch: make(chan time.Time, 1), period: d}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.schedule(t, c.now.Add(d))
+	return simTicker{t}
+}
+
+func (c *SimClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *SimClock) Sleep(d time.Duration) {
+	<-c.NewTimer(d).C()
+}
+
+// Advance moves the time forward by d, firing all timers that become due on the way.
+func (c *SimClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := c.now.Add(d)
+	for len(c.waiters) > 0 && !c.waiters[0].deadline.After(end) {
+		c.fireNext()
+	}
+	c.now = end
+}
+
+// AdvanceToNext moves the time forward to the earliest pending deadline and fires the timers that are due then.
+// It returns false, without moving the time, when nothing is pending.
+func (c *SimClock) AdvanceToNext() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.waiters) == 0 {
+		return false
+	}
+	next := c.waiters[0].deadline
+	for len(c.waiters) > 0 && !c.waiters[0].deadline.After(next) {
+		c.fireNext()
+	}
+	return true
+}
+
+// Pending returns the number of timers and tickers waiting to fire.
+func (c *SimClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil waits until at least n timers and tickers are waiting to fire.  Tests use it to make sure a service has
+// set its timer before advancing the time.
+func (c *SimClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.added.Wait()
+	}
+}
+
+// Called with the lock held.
+func (c *SimClock) schedule(w *simWaiter, deadline time.Time) {
+	w.deadline = deadline
+	w.seq = c.seq
+	c.seq++
+	heap.Push(&c.waiters, w)
+	c.added.Broadcast()
+}
+
+// Called with the lock held.
+func (c *SimClock) fireNext() {
+	w := heap.Pop(&c.waiters).(*simWaiter)
+	c.now = w.deadline
+	// Like the time package, drop the tick if the previous one was not consumed yet.
+	select {
+	case w.ch <- c.now:
+	default:
+	}
+	if w.period > 0 {
+		c.schedule(w, w.deadline.Add(w.period))
+	}
+}
+
+// Called with the lock held.
+func (c *SimClock) unschedule(w *simWaiter) bool {
+	if w.index < 0 {
+		return false
+	}
+	heap.Remove(&c.waiters, w.index)
+	return true
+}
+
+// A simulated timer or ticker.  Tickers have a positive period.
+type simWaiter struct {
+	clock    *SimClock
+	ch       chan time.Time
+	deadline time.Time
+	period   time.Duration
+	seq      int
+	// Position in the heap, -1 when not scheduled.
+	index int
+}
+
+func (w *simWaiter) C() <-chan time.Time {
+	return w.ch
+}
+
+func (w *simWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.clock.unschedule(w)
+}
+
+func (w *simWaiter) Reset(d time.Duration) bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	active := w.clock.unschedule(w)
+	w.clock.schedule(w, w.clock.now.Add(d))
+	return active
+}
+
+type simTicker struct{ *simWaiter }
+
+func (t simTicker) Stop() { t.simWaiter.Stop() }
+
+// A min heap of waiters, ordered by deadline.
+type waiterHeap []*simWaiter
+
+func (h waiterHeap) Len() int { return len(h) }
+
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].deadline.Equal(h[j].deadline) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].deadline.Before(h[j].deadline)
+}
+
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waiterHeap) Push(x interface{}) {
+	w := x.(*simWaiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waiterHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*h = old[:n-1]
+	return w
+}
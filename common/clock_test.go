@@ -0,0 +1,61 @@
+package common_test
+
+import (
+	"stream-first/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2019, 1, 2, 15, 4, 5, 0, time.UTC)
+
+func TestSimClock(t *testing.T) {
+	t.Run("Timers fire in deadline order when the clock is advanced past them", func(t *testing.T) {
+		c := common.NewSimClock(start)
+		late, early := c.NewTimer(2*time.Second), c.NewTimer(time.Second)
+		c.Advance(time.Second / 2)
+		assert.Len(t, early.C(), 0)
+		c.Advance(2 * time.Second)
+		require.Len(t, early.C(), 1)
+		require.Len(t, late.C(), 1)
+		assert.Equal(t, start.Add(time.Second), <-early.C())
+		assert.Equal(t, start.Add(2*time.Second), <-late.C())
+		assert.Equal(t, start.Add(2500*time.Millisecond), c.Now())
+	})
+	t.Run("AdvanceToNext jumps to the earliest deadline", func(t *testing.T) {
+		c := common.NewSimClock(start)
+		assert.False(t, c.AdvanceToNext())
+		c.NewTimer(time.Hour)
+		timer := c.NewTimer(time.Minute)
+		assert.True(t, c.AdvanceToNext())
+		assert.Equal(t, start.Add(time.Minute), c.Now())
+		assert.Len(t, timer.C(), 1)
+		assert.Equal(t, 1, c.Pending())
+	})
+	t.Run("Stopped timers do not fire, and reset timers fire at the new deadline", func(t *testing.T) {
+		c := common.NewSimClock(start)
+		stopped, reset := c.NewTimer(time.Second), c.NewTimer(time.Second)
+		assert.True(t, stopped.Stop())
+		reset.Reset(3 * time.Second)
+		c.Advance(2 * time.Second)
+		assert.Len(t, stopped.C(), 0)
+		assert.Len(t, reset.C(), 0)
+		c.Advance(time.Second)
+		assert.Equal(t, start.Add(3*time.Second), <-reset.C())
+		assert.False(t, stopped.Stop())
+	})
+	t.Run("Tickers fire every period until stopped", func(t *testing.T) {
+		c := common.NewSimClock(start)
+		ticker := c.NewTicker(time.Second)
+		for i := 1; i <= 3; i++ {
+			c.Advance(time.Second)
+			assert.Equal(t, start.Add(time.Duration(i)*time.Second), <-ticker.C())
+		}
+		ticker.Stop()
+		c.Advance(time.Second)
+		assert.Len(t, ticker.C(), 0)
+		assert.Equal(t, 0, c.Pending())
+	})
+}
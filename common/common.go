@@ -8,7 +8,8 @@ import (
 // The time in seconds to wait for the scheduler to visit other services
 var SchedulerDelay = 0.001
 
-// Used to make pubsub operation testable via mocks
+// The event bus the services communicate through.  Backends are cskr/pubsub within a process, and natsbus across
+// processes.  Also used to make pubsub operation testable via mocks.
 type PubsubInterface interface {
 	Pub(interface{}, ...string)
 	Sub(...string) chan interface{}
@@ -19,6 +20,22 @@ type RandInterface interface {
 	Rand() float64
 }
 
+// Drain passes the events already waiting on ch to handle, without waiting for more.  Services that keep a record of
+// the events drain their channels on shutdown, so that the events published before the shutdown are not lost.
+func Drain(ch chan interface{}, handle func(msg interface{})) {
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			handle(msg)
+		default:
+			return
+		}
+	}
+}
+
 func Seconds(seconds float64) time.Duration {
 	OneSecond := float64(time.Second)
 	return time.Duration(seconds * OneSecond)
@@ -2,7 +2,6 @@ package common
 
 import (
 	"fmt"
-	"time"
 )
 
 // Definitions for posting diagnostic messages.
@@ -17,7 +16,7 @@ var (
 // Diag posts a diagnostic message
 func Diag(ps PubsubInterface, serviceName string, severity string, message string, error error) {
 	ps.Pub(&DiagEvent{
-		Dt:          time.Now(),
+		Dt:          DiagClock.Now(),
 		ServiceName: serviceName,
 		Severity:    severity,
 		Message:     message,
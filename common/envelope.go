@@ -0,0 +1,155 @@
+package common
+
+// This file registers the event type of each topic, and defines the envelope events are wrapped in when they leave
+// the process.
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// EventType describes the events published on a topic.
+type EventType struct {
+	// The name of the event struct, for example "ShelvedEvent".
+	Name  string
+	Topic string
+	// Incremented whenever the json format of the event changes incompatibly.
+	Version int
+	// Pointer to the event struct.
+	typ reflect.Type
+}
+
+var (
+	eventTypesByTopic = map[string]*EventType{}
+	eventTypesByName  = map[string]*EventType{}
+	eventTypesByType  = map[reflect.Type]*EventType{}
+)
+
+func init() {
+	registerEventType(NewOrderTopic, 1, &NewOrderEvent{})
+	registerEventType(OrderCookedTopic, 1, &OrderCookedEvent{})
+	registerEventType(ShelvedTopic, 1, &ShelvedEvent{})
+	registerEventType(ReshelvedTopic, 1, &ReshelvedEvent{})
+	registerEventType(PickupTopic, 1, &PickupEvent{})
+	registerEventType(ExpiredTopic, 1, &ExpiredEvent{})
+	registerEventType(WasteTopic, 1, &WasteEvent{})
+	registerEventType(ValueTopic, 1, &ValueEvent{})
+	registerEventType(UserRequestTopic, 1, &UserRequestEvent{})
+	registerEventType(DiagTopic, 1, &DiagEvent{})
+	registerEventType(CourierDispatchedTopic, 1, &CourierDispatchedEvent{})
+	registerEventType(CourierArrivedTopic, 1, &CourierArrivedEvent{})
+}
+
+// Every event struct has a Dt field, and those about a single order have either an Order or an OrderID field.
+func registerEventType(topic string, version int, prototype interface{}) {
+	typ := reflect.TypeOf(prototype)
+	t := &EventType{Name: typ.Elem().Name(), Topic: topic, Version: version, typ: typ}
+	eventTypesByTopic[topic] = t
+	eventTypesByName[t.Name] = t
+	eventTypesByType[typ] = t
+}
+
+// EventTypeOf returns the type of the events published on topic.
+func EventTypeOf(topic string) (t *EventType, ok bool) {
+	t, ok = eventTypesByTopic[topic]
+	return
+}
+
+// ValidateEvent checks that msg is of the type registered for topic.
+func ValidateEvent(topic string, msg interface{}) error {
+	t, ok := eventTypesByTopic[topic]
+	if !ok {
+		return errors.Errorf("unknown topic %q", topic)
+	}
+	if reflect.TypeOf(msg) != t.typ {
+		return errors.Errorf("topic %q takes %v, got %T", topic, t.typ, msg)
+	}
+	if reflect.ValueOf(msg).IsNil() {
+		return errors.Errorf("topic %q takes %v, got nil", topic, t.typ)
+	}
+	return nil
+}
+
+// ValidatingPubsub publishes events only if they are of the types registered for their topics.  Other events are
+// dropped, and reported as errors from the publisher's side, instead of confusing each of the subscribers.
+type ValidatingPubsub struct {
+	PubsubInterface
+}
+
+func (ps ValidatingPubsub) Pub(msg interface{}, topics ...string) {
+	for _, topic := range topics {
+		if err := ValidateEvent(topic, msg); err != nil {
+			Diag(ps.PubsubInterface, "PubSub", Error, "", errors.Wrap(err, "event not published"))
+			return
+		}
+	}
+	ps.PubsubInterface.Pub(msg, topics...)
+}
+
+// Envelope wraps an event with its type and schema version, so that it can be decoded by other processes.
+type Envelope struct {
+	Type    string    `json:"type"`
+	Version int       `json:"version"`
+	ID      uuid.UUID `json:"id"`
+	// The ID of the order the event is about, if any.
+	CorrelationID uuid.UUID `json:"correlationId"`
+	Dt            time.Time `json:"dt"`
+	// Pointer to one of the event structs.
+	Payload interface{} `json:"payload"`
+}
+
+// NewEnvelope wraps an event, giving it a new ID.
+func NewEnvelope(msg interface{}) (envelope Envelope, err error) {
+	t, ok := eventTypesByType[reflect.TypeOf(msg)]
+	if !ok {
+		return envelope, errors.Errorf("unregistered event type %T", msg)
+	}
+	if reflect.ValueOf(msg).IsNil() {
+		return envelope, errors.Errorf("nil %v", t.Name)
+	}
+	event := reflect.ValueOf(msg).Elem()
+	envelope = Envelope{Type: t.Name, Version: t.Version, ID: uuid.New(), Payload: msg}
+	envelope.Dt = event.FieldByName("Dt").Interface().(time.Time)
+	if order := event.FieldByName("Order"); order.IsValid() {
+		envelope.CorrelationID = order.Interface().(Order).ID
+	} else if orderID := event.FieldByName("OrderID"); orderID.IsValid() {
+		envelope.CorrelationID = orderID.Interface().(uuid.UUID)
+	}
+	return
+}
+
+// Topic returns the topic the event is published on.
+func (e Envelope) Topic() string {
+	return eventTypesByName[e.Type].Topic
+}
+
+// UnmarshalJSON decodes the payload into the event struct named by the type.  Payloads of newer versions than the
+// registered ones are rejected.
+func (e *Envelope) UnmarshalJSON(data []byte) error {
+	type envelope Envelope
+	var raw struct {
+		envelope
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	t, ok := eventTypesByName[raw.Type]
+	if !ok {
+		return errors.Errorf("unknown event type %q", raw.Type)
+	}
+	if raw.Version > t.Version {
+		return errors.Errorf("%v version %v is newer than the supported version %v", t.Name, raw.Version, t.Version)
+	}
+	payload := reflect.New(t.typ.Elem()).Interface()
+	if err := json.Unmarshal(raw.Payload, payload); err != nil {
+		return errors.Wrapf(err, "%v payload", t.Name)
+	}
+	*e = Envelope(raw.envelope)
+	e.Payload = payload
+	return nil
+}
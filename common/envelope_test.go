@@ -0,0 +1,79 @@
+package common_test
+
+import (
+	"encoding/json"
+	"stream-first/common"
+	"stream-first/mocks"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope(t *testing.T) {
+	order := common.Order{ID: uuid.New(), Name: "Pizza", Temp: "hot", ShelfLife: 300, DecayRate: 0.45}
+	dt := time.Now().UTC().Round(0)
+
+	t.Run("Every topic's event round trips through json", func(t *testing.T) {
+		for _, msg := range []interface{}{
+			&common.NewOrderEvent{Dt: dt, Order: order},
+			&common.ShelvedEvent{Dt: dt, Order: order, Shelf: "hot"},
+			&common.ReshelvedEvent{Dt: dt, OrderID: order.ID, Shelf: common.OverflowShelfName},
+			&common.PickupEvent{Dt: dt, Order: order},
+			&common.ExpiredEvent{Dt: dt, Order: order},
+			&common.WasteEvent{Dt: dt, Order: order, Shelf: "hot", Reason: common.WasteExpiredOnPrimary},
+			&common.ValueEvent{Dt: dt, Shelf: "hot", Value: 10, NormValue: 0.5, Order: order},
+			&common.UserRequestEvent{Dt: dt, Request: "setArrivalRate", Rate: 5},
+			&common.DiagEvent{Dt: dt, ServiceName: "Shelf", Severity: common.Info, Message: "hello"},
+		} {
+			envelope, err := common.NewEnvelope(msg)
+			require.NoError(t, err)
+			require.NoError(t, common.ValidateEvent(envelope.Topic(), msg))
+			raw, err := json.Marshal(envelope)
+			require.NoError(t, err)
+
+			var decoded common.Envelope
+			require.NoError(t, json.Unmarshal(raw, &decoded), string(raw))
+			assert.Equal(t, msg, decoded.Payload)
+			assert.Equal(t, envelope.ID, decoded.ID)
+			assert.Equal(t, 1, decoded.Version)
+			assert.True(t, dt.Equal(decoded.Dt))
+		}
+	})
+	t.Run("The correlation ID is the order ID", func(t *testing.T) {
+		envelope, err := common.NewEnvelope(&common.ReshelvedEvent{Dt: dt, OrderID: order.ID})
+		require.NoError(t, err)
+		assert.Equal(t, order.ID, envelope.CorrelationID)
+		envelope, err = common.NewEnvelope(&common.DiagEvent{Dt: dt})
+		require.NoError(t, err)
+		assert.Equal(t, uuid.Nil, envelope.CorrelationID)
+	})
+	t.Run("Unknown types and newer versions are not decoded", func(t *testing.T) {
+		var envelope common.Envelope
+		assert.Error(t, json.Unmarshal([]byte(`{"type": "GossipEvent", "version": 1, "payload": {}}`), &envelope))
+		assert.Error(t, json.Unmarshal([]byte(`{"type": "PickupEvent", "version": 2, "payload": {}}`), &envelope))
+		_, err := common.NewEnvelope("pausePickup")
+		assert.Error(t, err)
+	})
+}
+
+func TestValidatingPubsub(t *testing.T) {
+	inner := &mocks.MockPubsub{}
+	inner.On("Pub", mock.Anything, mock.Anything)
+	ps := common.ValidatingPubsub{PubsubInterface: inner}
+
+	pickup := &common.PickupEvent{}
+	ps.Pub(pickup, common.PickupTopic)
+	inner.AssertCalled(t, "Pub", pickup, []string{common.PickupTopic})
+
+	// The wrong type, a nil event and an unknown topic are reported instead of published.
+	ps.Pub(pickup, common.ExpiredTopic)
+	ps.Pub((*common.ExpiredEvent)(nil), common.ExpiredTopic)
+	ps.Pub(pickup, "gossip")
+	inner.AssertNotCalled(t, "Pub", mock.Anything, []string{common.ExpiredTopic})
+	inner.AssertNotCalled(t, "Pub", mock.Anything, []string{"gossip"})
+	inner.AssertNumberOfCalls(t, "Pub", 4)
+}
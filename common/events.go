@@ -4,6 +4,7 @@ package common
 // used to propagate state through the system.
 
 import (
+	"encoding/json"
 	"github.com/google/uuid"
 	"time"
 )
@@ -15,26 +16,61 @@ type Order struct {
 	Temp      string  `json:"temp"`
 	ShelfLife float32 `json:"shelfLife"`
 	DecayRate float32 `json:"decayRate"`
+	// Seconds the order takes to cook.  0 leaves it to the kitchen, see the kitchen package.
+	PrepSeconds float32 `json:"prepSeconds,omitempty"`
+	// How the order loses value.  Orders without one decay linearly.
+	Decay *DecaySpec `json:"decay,omitempty"`
+}
+
+// DecaySpec selects the decay model of an order and its parameters, see shelflife.DecayModels.  Ages are effective
+// ages: seconds on the shelves, plus the decay rate times the seconds on the shelves weighted by shelf.
+type DecaySpec struct {
+	Model string `json:"model"`
+	// Exponential decay rate per second of effective age.
+	Rate float32 `json:"rate,omitempty"`
+	// Normalized values at effective ages, by age, for piecewise and table decay.
+	Points []DecayPoint `json:"points,omitempty"`
+}
+
+// DecayPoint is the normalized value of an order at an effective age in seconds.
+type DecayPoint struct {
+	Age   float32 `json:"age"`
+	Value float32 `json:"value"`
 }
 
 // pub/sub topics
 const (
 	NewOrderTopic    = "newOrder"
+	OrderCookedTopic = "orderCooked"
 	ShelvedTopic     = "shelved"
 	ReshelvedTopic   = "reshelved"
 	PickupTopic      = "pickup"
 	ExpiredTopic     = "expired"
+	WasteTopic       = "waste"
 	ValueTopic       = "value"
 	UserRequestTopic = "keyboard"
 	DiagTopic        = "diag"
+	// Courier movements, see the pickup package.
+	CourierDispatchedTopic = "courierDispatched"
+	CourierArrivedTopic    = "courierArrived"
 )
 
+// AllTopics lists every pub/sub topic.
+var AllTopics = []string{NewOrderTopic, OrderCookedTopic, ShelvedTopic, ReshelvedTopic, PickupTopic, ExpiredTopic,
+	WasteTopic, ValueTopic, UserRequestTopic, DiagTopic, CourierDispatchedTopic, CourierArrivedTopic}
+
 // A mew order arrived
 type NewOrderEvent struct {
 	Dt    time.Time
 	Order Order
 }
 
+// An order was cooked, and is ready to be shelved
+type OrderCookedEvent struct {
+	Dt    time.Time
+	Order Order
+}
+
 // A new order was shelved for the first time
 type ShelvedEvent struct {
 	Dt    time.Time
@@ -42,23 +78,59 @@ type ShelvedEvent struct {
 	Order Order
 }
 
-// An order was moved from the overflow shelf to primary
+// An order was moved between the overflow shelf and its primary shelf
 type ReshelvedEvent struct {
 	Dt      time.Time
 	OrderID uuid.UUID
+	// The shelf the order was moved to.
+	Shelf string
 }
 
 // WasteEvent fires when an order is declared waste
 type WasteEvent struct {
-	Dt     time.Time
-	Order  Order
+	Dt    time.Time
+	Order Order
+	// The shelf the order was on, empty if it was never shelved.
+	Shelf  string
 	Reason string
 }
 
+// Waste reasons
+const (
+	// Both the order's primary shelf and the overflow shelf were full when it arrived.
+	WasteShelvesFull = "shelvesFull"
+	// The order's value dropped to 0 while on a primary shelf.
+	WasteExpiredOnPrimary = "expiredOnPrimary"
+	// The order's value dropped to 0 while on the overflow shelf.
+	WasteExpiredOnOverflow = "expiredOnOverflow"
+	// The order was thrown away to make room for another.
+	WasteEvicted = "evicted"
+)
+
 // An order was picked up
 type PickupEvent struct {
 	Dt    time.Time
 	Order Order
+	// The courier that picked the order up, 0 if unknown, for example for replayed pickups.
+	CourierID int
+	// The pickup strategy the courier followed, empty if unknown.
+	Strategy string
+}
+
+// A courier was dispatched to the kitchen to pick an order up
+type CourierDispatchedEvent struct {
+	Dt        time.Time
+	CourierID int
+	OrderID   uuid.UUID
+	// When the courier is due at the kitchen.
+	DueAt time.Time
+}
+
+// A courier reached the kitchen, and waits for its order unless the order is ready
+type CourierArrivedEvent struct {
+	Dt        time.Time
+	CourierID int
+	OrderID   uuid.UUID
 }
 
 // An order expired
@@ -67,6 +139,14 @@ type ExpiredEvent struct {
 	Order Order
 }
 
+// The user made a request, from the keyboard or the control API.  Requests are listed in the userrequests package.
+type UserRequestEvent struct {
+	Dt      time.Time
+	Request string
+	// The new mean number of new orders per second, for requests that set it.
+	Rate float64
+}
+
 // The order shelf life manager posted an order's value
 type ValueEvent struct {
 	Dt        time.Time
@@ -83,3 +163,13 @@ type DiagEvent struct {
 	Message     string
 	Error       error
 }
+
+// MarshalJSON passes the error as the message, errors don't have a json format.
+func (e DiagEvent) MarshalJSON() ([]byte, error) {
+	type diagEvent DiagEvent
+	diag := diagEvent(e)
+	if diag.Error != nil {
+		diag.Message, diag.Error = diag.Error.Error(), nil
+	}
+	return json.Marshal(diag)
+}
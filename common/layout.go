@@ -0,0 +1,147 @@
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// Definitions for the shelf layout.  The layout is loaded once at startup and shared by every component that
+// needs to know which shelves exist and how many orders they hold, so that they can never disagree.
+
+// The name of the shelf that holds orders of all temperatures when their primary shelf is full.
+const OverflowShelfName = "overflow"
+
+// Decay modifiers used when the layout does not set one.  Orders on overflow are kept at the wrong temperature, and
+// decay at twice their rate.
+const (
+	DefaultDecayModifier         = 1
+	DefaultOverflowDecayModifier = 2
+)
+
+// A primary shelf, holding orders of a single temperature.  Orders on the shelf decay at DecayModifier times their
+// decay rate, DefaultDecayModifier if not set.  A modifier of 0 stops the decay, so that orders only age.
+type ShelfDefinition struct {
+	Temp          string   `json:"temp"`
+	Capacity      int      `json:"capacity"`
+	DecayModifier *float32 `json:"decayModifier,omitempty"`
+}
+
+// ShelfLayout lists the primary shelves, in display order, and the capacity and decay modifier of the overflow shelf.
+// The overflow decay modifier is DefaultOverflowDecayModifier if not set.
+type ShelfLayout struct {
+	Shelves               []ShelfDefinition `json:"shelves"`
+	OverflowCapacity      int               `json:"overflowCapacity"`
+	OverflowDecayModifier *float32          `json:"overflowDecayModifier,omitempty"`
+}
+
+// The layout used when none is configured.
+var DefaultShelfLayout = NewUniformShelfLayout([]string{"frozen", "cold", "hot"}, 15, 20)
+
+// NewUniformShelfLayout creates a layout where all primary shelves have the same capacity.
+func NewUniformShelfLayout(temps []string, primaryCapacity int, overflowCapacity int) ShelfLayout {
+	shelves := make([]ShelfDefinition, 0, len(temps))
+	for _, temp := range temps {
+		shelves = append(shelves, ShelfDefinition{Temp: temp, Capacity: primaryCapacity})
+	}
+	return ShelfLayout{Shelves: shelves, OverflowCapacity: overflowCapacity}
+}
+
+// LoadShelfLayout reads a layout from a json file and validates it.
+func LoadShelfLayout(path string) (layout ShelfLayout, err error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(raw, &layout); err != nil {
+		err = errors.Wrapf(err, "invalid shelf layout file %v", path)
+		return
+	}
+	err = layout.Validate()
+	return
+}
+
+// Validate checks that the layout is usable.
+func (l ShelfLayout) Validate() error {
+	if len(l.Shelves) == 0 {
+		return errors.New("shelf layout: at least one primary shelf is required")
+	}
+	seen := map[string]bool{}
+	for _, shelf := range l.Shelves {
+		if shelf.Temp == "" {
+			return errors.New("shelf layout: shelf temp must not be empty")
+		}
+		if shelf.Temp == OverflowShelfName {
+			return errors.Errorf("shelf layout: %q is reserved for the overflow shelf", OverflowShelfName)
+		}
+		if seen[shelf.Temp] {
+			return errors.Errorf("shelf layout: duplicate shelf for temp %q", shelf.Temp)
+		}
+		seen[shelf.Temp] = true
+		if shelf.Capacity <= 0 {
+			return errors.Errorf("shelf layout: capacity of %q shelf must be positive, got %v", shelf.Temp, shelf.Capacity)
+		}
+		if shelf.DecayModifier != nil && *shelf.DecayModifier < 0 {
+			return errors.Errorf("shelf layout: decay modifier of %q shelf must not be negative, got %v", shelf.Temp,
+				*shelf.DecayModifier)
+		}
+	}
+	if l.OverflowCapacity < 0 {
+		return errors.Errorf("shelf layout: overflow capacity must not be negative, got %v", l.OverflowCapacity)
+	}
+	if l.OverflowDecayModifier != nil && *l.OverflowDecayModifier < 0 {
+		return errors.Errorf("shelf layout: overflow decay modifier must not be negative, got %v",
+			*l.OverflowDecayModifier)
+	}
+	return nil
+}
+
+// Temps returns the temperatures that have a primary shelf.
+func (l ShelfLayout) Temps() (temps []string) {
+	for _, shelf := range l.Shelves {
+		temps = append(temps, shelf.Temp)
+	}
+	return
+}
+
+// ShelfNames returns the names of all shelves, primary shelves first and overflow last.
+func (l ShelfLayout) ShelfNames() []string {
+	return append(l.Temps(), OverflowShelfName)
+}
+
+// HasShelf reports whether the layout includes a shelf with the given name.
+func (l ShelfLayout) HasShelf(name string) bool {
+	_, ok := l.Capacity(name)
+	return ok
+}
+
+// Capacity returns the capacity of the named shelf.
+func (l ShelfLayout) Capacity(name string) (capacity int, ok bool) {
+	if name == OverflowShelfName {
+		return l.OverflowCapacity, true
+	}
+	for _, shelf := range l.Shelves {
+		if shelf.Temp == name {
+			return shelf.Capacity, true
+		}
+	}
+	return
+}
+
+// DecayModifier returns the factor applied to the decay rate of orders on the named shelf.  Unknown shelves get
+// DefaultDecayModifier.
+func (l ShelfLayout) DecayModifier(name string) float32 {
+	if name == OverflowShelfName {
+		if l.OverflowDecayModifier != nil {
+			return *l.OverflowDecayModifier
+		}
+		return DefaultOverflowDecayModifier
+	}
+	for _, shelf := range l.Shelves {
+		if shelf.Temp == name && shelf.DecayModifier != nil {
+			return *shelf.DecayModifier
+		}
+	}
+	return DefaultDecayModifier
+}
@@ -0,0 +1,39 @@
+package common_test
+
+import (
+	"stream-first/common"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func modifier(m float32) *float32 {
+	return &m
+}
+
+func TestShelfLayout_DecayModifier(t *testing.T) {
+	layout := common.ShelfLayout{
+		Shelves: []common.ShelfDefinition{
+			{Temp: "hot", Capacity: 1, DecayModifier: modifier(1.5)}, {Temp: "cold", Capacity: 1},
+			{Temp: "frozen", Capacity: 1, DecayModifier: modifier(0)}},
+		OverflowCapacity: 1,
+	}
+	assert.Equal(t, float32(1.5), layout.DecayModifier("hot"))
+	assert.Equal(t, float32(common.DefaultDecayModifier), layout.DecayModifier("cold"))
+	// A modifier of 0 is kept rather than taken as unset.
+	assert.Equal(t, float32(0), layout.DecayModifier("frozen"))
+	assert.Equal(t, float32(common.DefaultOverflowDecayModifier), layout.DecayModifier(common.OverflowShelfName))
+	layout.OverflowDecayModifier = modifier(0)
+	assert.Equal(t, float32(0), layout.DecayModifier(common.OverflowShelfName))
+	assert.NoError(t, layout.Validate())
+}
+
+func TestShelfLayout_Validate(t *testing.T) {
+	layout := common.NewUniformShelfLayout([]string{"hot", "cold"}, 1, 1)
+	assert.NoError(t, layout.Validate())
+	layout.Shelves[0].DecayModifier = modifier(-1)
+	assert.Error(t, layout.Validate())
+	layout.Shelves[0].DecayModifier = nil
+	layout.OverflowDecayModifier = modifier(-1)
+	assert.Error(t, layout.Validate())
+}
@@ -0,0 +1,32 @@
+package common
+
+import (
+	"hash/fnv"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+// Definitions for reproducible randomness.  Every service draws from its own source, derived from a single master
+// seed and the service name, so that a run can be replayed by reusing its seed, and a service's random draws don't
+// depend on how many draws other services made.
+
+// NewSeed returns a master seed for runs that don't specify one.
+func NewSeed() uint64 {
+	return uint64(time.Now().UnixNano())
+}
+
+// NewSource returns the random source for the named service.
+func NewSource(seed uint64, serviceName string) rand.Source {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(serviceName))
+	return rand.NewSource(splitMix64(seed ^ h.Sum64()))
+}
+
+// splitMix64 scrambles the bits of x, so that related seeds produce unrelated sources.
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
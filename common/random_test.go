@@ -0,0 +1,27 @@
+package common_test
+
+import (
+	"stream-first/common"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSource(t *testing.T) {
+	draw := func(seed uint64, name string) (draws []uint64) {
+		src := common.NewSource(seed, name)
+		for i := 0; i < 3; i++ {
+			draws = append(draws, src.Uint64())
+		}
+		return
+	}
+	t.Run("Sources with the same seed and name produce the same draws", func(t *testing.T) {
+		assert.Equal(t, draw(42, "Pickup"), draw(42, "Pickup"))
+	})
+	t.Run("Services get different draws from the same seed", func(t *testing.T) {
+		assert.NotEqual(t, draw(42, "Pickup"), draw(42, "OrderSender"))
+	})
+	t.Run("Different seeds produce different draws", func(t *testing.T) {
+		assert.NotEqual(t, draw(42, "Pickup"), draw(43, "Pickup"))
+	})
+}
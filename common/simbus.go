@@ -0,0 +1,243 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// SimBus is the event bus of runs on a SimClock.  Events are delivered one at a time, to one subscriber at a time,
+// and only once every other goroutine is blocked, so that the subscriber reacts to the event before anything else
+// happens.  The clock only moves once all events are delivered.  A run then goes through the same steps in the same
+// order every time, and the same seed gives the same run.
+type SimBus struct {
+	// The bus state is guarded by the clock lock, so that publishing wakes Drive just as setting a timer does.
+	clock      *SimClock
+	bufferSize int
+	// Subscriptions in delivery order.
+	subs []*simSub
+	// Subscriptions made so far by each function.
+	subCounts map[string]int
+	queue     []simDelivery
+}
+
+// Subscriptions are made concurrently, so they are ordered by the function that made them rather than by time.
+type simSub struct {
+	caller string
+	// Position among the subscriptions of caller.
+	n      int
+	topics map[string]bool
+	ch     chan interface{}
+}
+
+type simDelivery struct {
+	sub *simSub
+	msg interface{}
+}
+
+func NewSimBus(clock *SimClock, bufferSize int) *SimBus {
+	return &SimBus{clock: clock, bufferSize: bufferSize, subCounts: map[string]int{}}
+}
+
+func (b *SimBus) Sub(topics ...string) chan interface{} {
+	caller := externalCaller()
+	b.clock.mu.Lock()
+	defer b.clock.mu.Unlock()
+	s := &simSub{caller: caller, n: b.subCounts[caller], topics: map[string]bool{},
+		ch: make(chan interface{}, b.bufferSize)}
+	b.subCounts[caller]++
+	for _, topic := range topics {
+		s.topics[topic] = true
+	}
+	i := sort.Search(len(b.subs), func(i int) bool {
+		other := b.subs[i]
+		return caller < other.caller || caller == other.caller && s.n < other.n
+	})
+	b.subs = append(b.subs, nil)
+	copy(b.subs[i+1:], b.subs[i:])
+	b.subs[i] = s
+	return s.ch
+}
+
+// Pub queues msg for the subscribers of any of topics.  It does not block.
+func (b *SimBus) Pub(msg interface{}, topics ...string) {
+	b.clock.mu.Lock()
+	defer b.clock.mu.Unlock()
+	for _, s := range b.subs {
+		for _, topic := range topics {
+			if s.topics[topic] {
+				b.queue = append(b.queue, simDelivery{sub: s, msg: msg})
+				break
+			}
+		}
+	}
+	b.clock.added.Broadcast()
+}
+
+// Drive runs the simulation until ctx is done.  Once every other goroutine is blocked, it delivers the next events,
+// or when none is queued, it fires the next timer.  With neither, it waits for an event or a timer.
+func (b *SimBus) Drive(ctx context.Context) {
+	c := b.clock
+	go func() {
+		<-ctx.Done()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.added.Broadcast()
+	}()
+	var stacks []byte
+	for {
+		c.mu.Lock()
+		for len(b.queue) == 0 && len(c.waiters) == 0 && ctx.Err() == nil {
+			c.added.Wait()
+		}
+		c.mu.Unlock()
+		stacks = waitBlocked(ctx, stacks)
+		c.mu.Lock()
+		if ctx.Err() != nil {
+			c.mu.Unlock()
+			return
+		}
+		if len(b.queue) == 0 {
+			// The timers may have been stopped meanwhile.
+			if len(c.waiters) > 0 {
+				c.fireNext()
+			}
+			c.mu.Unlock()
+			continue
+		}
+		sub, msgs := b.nextBatch()
+		c.mu.Unlock()
+		for i, msg := range msgs {
+			select {
+			case sub.ch <- msg:
+			case <-ctx.Done():
+				// Left for Flush.
+				c.mu.Lock()
+				left := make([]simDelivery, 0, len(msgs)-i+len(b.queue))
+				for _, msg := range msgs[i:] {
+					left = append(left, simDelivery{sub: sub, msg: msg})
+				}
+				b.queue = append(left, b.queue...)
+				c.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// Take the events queued for the subscriber of the next event, as many as its channel has room for, or at least one.
+// The subscriber goes through them in publishing order before anything else happens, so one step per subscriber
+// keeps runs repeatable just as one step per event would.  Called with the lock held.
+func (b *SimBus) nextBatch() (*simSub, []interface{}) {
+	sub := b.queue[0].sub
+	room := cap(sub.ch) - len(sub.ch)
+	if room < 1 {
+		room = 1
+	}
+	var msgs []interface{}
+	left := b.queue[:0]
+	for _, d := range b.queue {
+		if d.sub == sub && len(msgs) < room {
+			msgs = append(msgs, d.msg)
+		} else {
+			left = append(left, d)
+		}
+	}
+	// Let go of the delivered events.
+	for i := len(left); i < len(b.queue); i++ {
+		b.queue[i] = simDelivery{}
+	}
+	b.queue = left
+	return sub, msgs
+}
+
+// Flush delivers the events still queued once Drive returned, so that the report and the event log see the last
+// events of the run.  Subscribers that stopped reading, the services that ended with the run, miss them.
+func (b *SimBus) Flush() {
+	c := b.clock
+	var stacks []byte
+	for {
+		stacks = waitBlocked(context.Background(), stacks)
+		c.mu.Lock()
+		if len(b.queue) == 0 {
+			c.mu.Unlock()
+			return
+		}
+		d := b.queue[0]
+		b.queue = b.queue[1:]
+		c.mu.Unlock()
+		// A subscriber blocked on a full channel is not reading it.
+		select {
+		case d.sub.ch <- d.msg:
+		default:
+		}
+	}
+}
+
+// Wait until every other goroutine is blocked, or ctx is done.  stacks is reused across calls.
+func waitBlocked(ctx context.Context, stacks []byte) []byte {
+	for {
+		// Let the goroutines woken by the last step run before looking.
+		runtime.Gosched()
+		var blocked bool
+		if stacks, blocked = othersBlocked(stacks); blocked || ctx.Err() != nil {
+			return stacks
+		}
+	}
+}
+
+// Goroutine states, as printed in stack traces, of goroutines waiting for something else to happen.  The others are
+// running, or sleeping in real time, and will go on by themselves.
+var blockedStates = []string{"chan receive", "chan send", "select", "sync.", "semacquire", "IO wait", "syscall"}
+
+// Tell whether every goroutine but the caller is blocked.  stacks is reused across calls.  Stack traces are the only
+// place the runtime tells goroutine states, stack records alone do not tell a woken goroutine from a blocked one.
+func othersBlocked(stacks []byte) ([]byte, bool) {
+	if len(stacks) == 0 {
+		stacks = make([]byte, 64*1024)
+	}
+	n := runtime.Stack(stacks, true)
+	for n == len(stacks) {
+		stacks = make([]byte, 2*len(stacks))
+		n = runtime.Stack(stacks, true)
+	}
+	// Traces are separated by blank lines, and the first one is the caller's.
+	for _, trace := range bytes.Split(stacks[:n], []byte("\n\n"))[1:] {
+		// goroutine 7 [chan receive, 2 minutes]:
+		start, end := bytes.IndexByte(trace, '['), bytes.IndexByte(trace, ']')
+		if start < 0 || end < start {
+			return stacks, false
+		}
+		state := string(trace[start+1 : end])
+		blocked := false
+		for _, prefix := range blockedStates {
+			blocked = blocked || strings.HasPrefix(state, prefix)
+		}
+		if !blocked {
+			return stacks, false
+		}
+	}
+	return stacks, true
+}
+
+// The name of the function outside this package that called into it.
+func externalCaller() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, commonPackage) || !more {
+			return frame.Function
+		}
+	}
+}
+
+// The prefix of the function names of this package.
+var commonPackage = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name()
+	slash := strings.LastIndex(name, "/") + 1
+	return name[:slash+strings.IndexByte(name[slash:], '.')+1]
+}()
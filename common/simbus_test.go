@@ -0,0 +1,70 @@
+package common_test
+
+import (
+	"context"
+	"stream-first/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimBus(t *testing.T) {
+	t.Run("The clock waits for subscribers that are still busy with an event", func(t *testing.T) {
+		c := common.NewSimClock(start)
+		bus := common.NewSimBus(c, 1)
+		inCh, outCh := bus.Sub("in"), bus.Sub("out")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			for range inCh {
+				// Slower than any fixed delay given to the subscribers would be.
+				time.Sleep(20 * time.Millisecond)
+				bus.Pub(c.Now(), "out")
+			}
+		}()
+		timer := c.NewTimer(time.Second)
+		bus.Pub("event", "in")
+		go bus.Drive(ctx)
+		assert.Equal(t, start, <-outCh)
+		assert.Equal(t, start.Add(time.Second), <-timer.C())
+	})
+	t.Run("Events reach each subscriber in publishing order", func(t *testing.T) {
+		c := common.NewSimClock(start)
+		bus := common.NewSimBus(c, 1)
+		aCh, bCh := bus.Sub("a"), bus.Sub("a", "b")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		for i := 0; i < 3; i++ {
+			bus.Pub(i, "a", "b")
+		}
+		go bus.Drive(ctx)
+		for i := 0; i < 3; i++ {
+			require.Equal(t, i, <-aCh)
+			require.Equal(t, i, <-bCh, "once per subscriber, even if subscribed to several of the topics")
+		}
+	})
+	t.Run("Flush delivers the events left once the run ends, skipping subscribers that stopped reading", func(t *testing.T) {
+		c := common.NewSimClock(start)
+		bus := common.NewSimBus(c, 1)
+		stoppedCh, sinkCh := bus.Sub("event"), bus.Sub("event")
+		received := make(chan []interface{})
+		go func() {
+			var events []interface{}
+			for e := range sinkCh {
+				events = append(events, e)
+			}
+			received <- events
+		}()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		bus.Drive(ctx)
+		bus.Pub(1, "event")
+		bus.Pub(2, "event")
+		bus.Flush()
+		close(sinkCh)
+		assert.Equal(t, []interface{}{1, 2}, <-received)
+		assert.Len(t, stoppedCh, 1)
+	})
+}
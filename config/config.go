@@ -0,0 +1,314 @@
+package config
+
+// The config package collects the simulation parameters.  Parameters start at their defaults, are overridden by the
+// config file given with -config (json, yaml or toml, chosen by file extension), and finally by any flags set on the
+// command line.
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds the parameters of a simulation run.
+type Config struct {
+	// Sample orders replayed by the order sender.
+	OrdersFile string `json:"ordersFile" yaml:"ordersFile" toml:"ordersFile"`
+	// Shelf layout definition, see common.ShelfLayout.
+	ShelfLayoutFile string `json:"shelfLayoutFile" yaml:"shelfLayoutFile" toml:"shelfLayoutFile"`
+	// Mean number of new orders per second.
+	ArrivalRate float64 `json:"arrivalRate" yaml:"arrivalRate" toml:"arrivalRate"`
+	// Cooking stations by temp.  New orders wait for a station of their temp to be free.
+	Stations map[string]int `json:"stations" yaml:"stations" toml:"stations"`
+	// Orders without a prep time of their own cook for a uniformly distributed number of seconds within this range.
+	PrepMinSeconds float64 `json:"prepMinSeconds" yaml:"prepMinSeconds" toml:"prepMinSeconds"`
+	PrepMaxSeconds float64 `json:"prepMaxSeconds" yaml:"prepMaxSeconds" toml:"prepMaxSeconds"`
+	// Couriers reach the kitchen a uniformly distributed number of seconds after they are dispatched, within this
+	// range.  Delivering an order takes another such trip.
+	PickupMinSeconds float64 `json:"pickupMinSeconds" yaml:"pickupMinSeconds" toml:"pickupMinSeconds"`
+	PickupMaxSeconds float64 `json:"pickupMaxSeconds" yaml:"pickupMaxSeconds" toml:"pickupMaxSeconds"`
+	// Size of the courier fleet.  Orders wait for a courier to be dispatched while all of them are busy.
+	Couriers int `json:"couriers" yaml:"couriers" toml:"couriers"`
+	// Which ready order a courier at the kitchen picks up, see pickup.PickupStrategies.
+	PickupStrategy string `json:"pickupStrategy" yaml:"pickupStrategy" toml:"pickupStrategy"`
+	// Order values are published at least this often.
+	KeepAliveSeconds float64 `json:"keepAliveSeconds" yaml:"keepAliveSeconds" toml:"keepAliveSeconds"`
+	// Capacity of each pub/sub subscription channel.
+	BufferSize int `json:"bufferSize" yaml:"bufferSize" toml:"bufferSize"`
+	// Run without the terminal UI, logging diagnostics to stderr.
+	Headless bool `json:"headless" yaml:"headless" toml:"headless"`
+	// Stop sending orders after this many, and end a headless run once they are all resolved. 0 means no limit.
+	MaxOrders int `json:"maxOrders" yaml:"maxOrders" toml:"maxOrders"`
+	// End a headless run after this many seconds. 0 means no limit.
+	RunSeconds float64 `json:"runSeconds" yaml:"runSeconds" toml:"runSeconds"`
+	// RealClock runs in real time, SimClock as fast as the services keep up.
+	Clock string `json:"clock" yaml:"clock" toml:"clock"`
+	// Master seed for all random draws.  Runs with the same seed are the same.  0 picks a new seed.
+	Seed uint64 `json:"seed" yaml:"seed" toml:"seed"`
+	// The end of run summary is saved to this file in json format, in addition to being printed.
+	ReportFile string `json:"reportFile" yaml:"reportFile" toml:"reportFile"`
+	// What to throw away when an order arrives and both its primary shelf and the overflow shelf are full, see
+	// shelf.EvictionPolicies.
+	Eviction string `json:"eviction" yaml:"eviction" toml:"eviction"`
+	// Which overflow order moves to a primary shelf when space becomes available on it, see shelf.ReshelfStrategies.
+	Reshelving string `json:"reshelving" yaml:"reshelving" toml:"reshelving"`
+	// Orders are swapped between primary and overflow shelves this often when that adds value. 0 disables swapping.
+	RebalanceSeconds float64 `json:"rebalanceSeconds" yaml:"rebalanceSeconds" toml:"rebalanceSeconds"`
+	// Address the HTTP API listens on, for example ":8080".  Empty disables the API.
+	HTTPAddr string `json:"httpAddr" yaml:"httpAddr" toml:"httpAddr"`
+	// Unix socket the control API listens on, for example "/tmp/stream-first.sock".  Empty disables the socket.
+	ControlSocket string `json:"controlSocket" yaml:"controlSocket" toml:"controlSocket"`
+	// Every event is appended to this file as newline delimited json.  Empty disables recording.
+	RecordFile string `json:"recordFile" yaml:"recordFile" toml:"recordFile"`
+	// New orders and pickups are replayed from this recording instead of being generated.  Empty disables replay.
+	ReplayFile string `json:"replayFile" yaml:"replayFile" toml:"replayFile"`
+	// How fast a recording is replayed, see eventlog.PaceOriginal and eventlog.PaceFast.
+	ReplayPace string `json:"replayPace" yaml:"replayPace" toml:"replayPace"`
+	// The event bus: LocalBus within the process, or the url of a NATS server, for example "nats://localhost:4222",
+	// to share events with services in other processes.
+	Bus string `json:"bus" yaml:"bus" toml:"bus"`
+}
+
+// Clock choices
+const (
+	RealClock = "real"
+	SimClock  = "sim"
+)
+
+// LocalBus keeps events within the process.
+const LocalBus = "local"
+
+// Default returns the parameters used when neither a config file nor flags override them.
+func Default() Config {
+	return Config{
+		OrdersFile:       "data/orders.json",
+		ShelfLayoutFile:  "data/shelves.json",
+		ArrivalRate:      3.25,
+		Stations:         map[string]int{"hot": 6, "cold": 6, "frozen": 6},
+		PrepMinSeconds:   1,
+		PrepMaxSeconds:   5,
+		PickupMinSeconds: 2,
+		PickupMaxSeconds: 10,
+		Couriers:         60,
+		PickupStrategy:   "matched",
+		KeepAliveSeconds: 1,
+		BufferSize:       1000,
+		Clock:            RealClock,
+		Eviction:         "discard-new",
+		Reshelving:       "highest-decay",
+		ReplayPace:       "original",
+		Bus:              LocalBus,
+	}
+}
+
+// Load builds the configuration from the command line arguments (excluding the program name).
+func Load(name string, args []string) (cfg Config, err error) {
+	cfg = Default()
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", "", "config file (.json, .yaml, .yml or .toml)")
+	cfg.register(fs)
+	if err = fs.Parse(args); err != nil {
+		return
+	}
+	if fs.NArg() > 0 {
+		err = errors.Errorf("unexpected arguments: %v", strings.Join(fs.Args(), " "))
+		return
+	}
+
+	if *configFile != "" {
+		fileCfg := Default()
+		if err = fileCfg.readFile(*configFile); err != nil {
+			return
+		}
+		// Flags set on the command line take precedence over the file.
+		overrides := flag.NewFlagSet(name, flag.ContinueOnError)
+		fileCfg.register(overrides)
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name != "config" && err == nil {
+				err = overrides.Set(f.Name, f.Value.String())
+			}
+		})
+		if err != nil {
+			return
+		}
+		cfg = fileCfg
+	}
+
+	err = cfg.Validate()
+	return
+}
+
+func (c *Config) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.OrdersFile, "orders", c.OrdersFile, "sample orders file")
+	fs.StringVar(&c.ShelfLayoutFile, "shelves", c.ShelfLayoutFile, "shelf layout file")
+	fs.Float64Var(&c.ArrivalRate, "rate", c.ArrivalRate, "mean number of new orders per second")
+	fs.Var((*stationsFlag)(&c.Stations), "stations", "cooking stations by temp, for example hot=6,cold=6,frozen=6")
+	fs.Float64Var(&c.PrepMinSeconds, "prep-min", c.PrepMinSeconds, "minimum seconds to cook an order")
+	fs.Float64Var(&c.PrepMaxSeconds, "prep-max", c.PrepMaxSeconds, "maximum seconds to cook an order")
+	fs.Float64Var(&c.PickupMinSeconds, "pickup-min", c.PickupMinSeconds,
+		"minimum seconds for a dispatched courier to reach the kitchen")
+	fs.Float64Var(&c.PickupMaxSeconds, "pickup-max", c.PickupMaxSeconds,
+		"maximum seconds for a dispatched courier to reach the kitchen")
+	fs.IntVar(&c.Couriers, "couriers", c.Couriers, "number of couriers")
+	fs.StringVar(&c.PickupStrategy, "pickup-strategy", c.PickupStrategy,
+		"order a courier picks up: matched for its own, fifo for the longest ready, or lowest-value")
+	fs.Float64Var(&c.KeepAliveSeconds, "keep-alive", c.KeepAliveSeconds, "maximum seconds between order value updates")
+	fs.IntVar(&c.BufferSize, "buffer", c.BufferSize, "pub/sub subscription buffer size")
+	fs.BoolVar(&c.Headless, "headless", c.Headless, "run without the terminal UI")
+	fs.IntVar(&c.MaxOrders, "max-orders", c.MaxOrders, "number of orders to send, 0 for no limit")
+	fs.Float64Var(&c.RunSeconds, "duration", c.RunSeconds, "seconds until a headless run ends, 0 for no limit")
+	fs.StringVar(&c.Clock, "clock", c.Clock, "real, or sim to run faster than real time")
+	fs.Uint64Var(&c.Seed, "seed", c.Seed, "master random seed, 0 for a new one")
+	fs.StringVar(&c.ReportFile, "report", c.ReportFile, "json file for the end of run summary")
+	fs.StringVar(&c.Eviction, "eviction", c.Eviction,
+		"eviction policy when shelves are full: discard-new, evict-lowest-value, evict-random or evict-highest-decay")
+	fs.StringVar(&c.Reshelving, "reshelving", c.Reshelving,
+		"reshelf strategy: highest-decay, lowest-value, soonest-to-expire or max-value-saved")
+	fs.Float64Var(&c.RebalanceSeconds, "rebalance", c.RebalanceSeconds,
+		"seconds between swapping orders between primary and overflow shelves, 0 to disable")
+	fs.StringVar(&c.HTTPAddr, "http", c.HTTPAddr, "HTTP API listen address, for example :8080; empty to disable")
+	fs.StringVar(&c.ControlSocket, "control-socket", c.ControlSocket, "Unix socket for the control API; empty to disable")
+	fs.StringVar(&c.RecordFile, "record", c.RecordFile, "file to record every event to; empty to disable")
+	fs.StringVar(&c.ReplayFile, "replay", c.ReplayFile, "recorded file to replay new orders and pickups from")
+	fs.StringVar(&c.ReplayPace, "replay-pace", c.ReplayPace,
+		"original to keep the recorded time between events, or fast")
+	fs.StringVar(&c.Bus, "bus", c.Bus, "event bus: local, or a NATS server url such as nats://localhost:4222")
+}
+
+func (c *Config) readFile(path string) (err error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	// Maps set in the file replace the defaults, like the flags do, rather than being merged into them.
+	stations := c.Stations
+	c.Stations = nil
+	defer func() {
+		if c.Stations == nil {
+			c.Stations = stations
+		}
+	}()
+	// Unknown keys are rejected, so that typos don't silently fall back to defaults.
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(c)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(raw, c)
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(raw), c)
+		if undecoded := md.Undecoded(); err == nil && len(undecoded) > 0 {
+			err = errors.Errorf("unknown keys %v", undecoded)
+		}
+	default:
+		return errors.Errorf("config file %v: unsupported extension %q, expected .json, .yaml, .yml or .toml", path, ext)
+	}
+	return errors.Wrapf(err, "config file %v", path)
+}
+
+// Validate checks that the parameters are usable, and describes the first problem found.
+func (c Config) Validate() error {
+	switch {
+	case c.OrdersFile == "":
+		return errors.New("orders file must be set")
+	case c.ShelfLayoutFile == "":
+		return errors.New("shelf layout file must be set")
+	case c.ArrivalRate <= 0:
+		return errors.Errorf("arrival rate must be positive, got %v", c.ArrivalRate)
+	case len(c.Stations) == 0:
+		return errors.New("cooking stations must be set")
+	case c.PrepMinSeconds < 0:
+		return errors.Errorf("prep minimum must not be negative, got %v", c.PrepMinSeconds)
+	case c.PrepMaxSeconds < c.PrepMinSeconds:
+		return errors.Errorf("prep maximum (%v) must not be less than the minimum (%v)", c.PrepMaxSeconds, c.PrepMinSeconds)
+	case c.PickupMinSeconds < 0:
+		return errors.Errorf("pickup minimum must not be negative, got %v", c.PickupMinSeconds)
+	case c.PickupMaxSeconds < c.PickupMinSeconds:
+		return errors.Errorf("pickup maximum (%v) must not be less than the minimum (%v)", c.PickupMaxSeconds, c.PickupMinSeconds)
+	case c.Couriers < 1:
+		return errors.Errorf("there must be at least 1 courier, got %v", c.Couriers)
+	case c.PickupStrategy == "":
+		return errors.New("pickup strategy must be set")
+	case c.KeepAliveSeconds <= 0:
+		return errors.Errorf("keep alive interval must be positive, got %v", c.KeepAliveSeconds)
+	case c.BufferSize < 1:
+		return errors.Errorf("buffer size must be at least 1, got %v", c.BufferSize)
+	case c.MaxOrders < 0:
+		return errors.Errorf("max orders must not be negative, got %v", c.MaxOrders)
+	case c.RunSeconds < 0:
+		return errors.Errorf("duration must not be negative, got %v", c.RunSeconds)
+	case c.Headless && c.MaxOrders == 0 && c.RunSeconds == 0:
+		return errors.New("headless mode requires max orders or a duration to end the run")
+	case c.Clock != RealClock && c.Clock != SimClock:
+		return errors.Errorf("clock must be %q or %q, got %q", RealClock, SimClock, c.Clock)
+	case c.Eviction == "":
+		return errors.New("eviction policy must be set")
+	case c.Reshelving == "":
+		return errors.New("reshelf strategy must be set")
+	case c.RebalanceSeconds < 0:
+		return errors.Errorf("rebalance interval must not be negative, got %v", c.RebalanceSeconds)
+	case c.ReplayPace != "original" && c.ReplayPace != "fast":
+		return errors.Errorf("replay pace must be %q or %q, got %q", "original", "fast", c.ReplayPace)
+	case c.ReplayFile != "" && c.ReplayFile == c.RecordFile:
+		return errors.New("a run can't record to the file it replays")
+	case c.Bus != LocalBus && !strings.HasPrefix(c.Bus, "nats://"):
+		return errors.Errorf("bus must be %q or a nats:// url, got %q", LocalBus, c.Bus)
+	case c.Clock == SimClock && c.Bus != LocalBus:
+		return errors.Errorf("the %q clock needs the %q bus", SimClock, LocalBus)
+	}
+	for temp, count := range c.Stations {
+		if count < 1 {
+			return errors.Errorf("there must be at least 1 cooking station for %v orders, got %v", temp, count)
+		}
+	}
+	return nil
+}
+
+// Parses the -stations flag.  The flag replaces the stations of the defaults or the config file, rather than adding
+// to them, so that its value reads the same on its own.
+type stationsFlag map[string]int
+
+func (f *stationsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	temps := make([]string, 0, len(*f))
+	for temp := range *f {
+		temps = append(temps, temp)
+	}
+	sort.Strings(temps)
+	pairs := make([]string, len(temps))
+	for i, temp := range temps {
+		pairs[i] = fmt.Sprintf("%v=%v", temp, (*f)[temp])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f *stationsFlag) Set(value string) error {
+	stations := stationsFlag{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return errors.Errorf("expected temp=count, got %q", pair)
+		}
+		count, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return errors.Errorf("expected temp=count, got %q", pair)
+		}
+		stations[strings.TrimSpace(parts[0])] = count
+	}
+	*f = stations
+	return nil
+}
@@ -0,0 +1,106 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"stream-first/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, name string, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("Load returns the defaults when no arguments are given", func(t *testing.T) {
+		cfg, err := config.Load("test", nil)
+		require.NoError(t, err)
+		assert.Equal(t, config.Default(), cfg)
+	})
+	t.Run("Flags override the defaults", func(t *testing.T) {
+		cfg, err := config.Load("test", []string{"-rate", "5", "-pickup-max", "12"})
+		require.NoError(t, err)
+		assert.Equal(t, 5.0, cfg.ArrivalRate)
+		assert.Equal(t, 12.0, cfg.PickupMaxSeconds)
+		assert.Equal(t, config.Default().PickupMinSeconds, cfg.PickupMinSeconds)
+	})
+	t.Run("The stations flag replaces the default stations", func(t *testing.T) {
+		cfg, err := config.Load("test", []string{"-stations", "hot=2,cold=1"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"hot": 2, "cold": 1}, cfg.Stations)
+		_, err = config.Load("test", []string{"-stations", "hot"})
+		assert.Error(t, err)
+	})
+	for _, tt := range []struct{ name, content string }{
+		{"c.json", `{"arrivalRate": 1.5, "bufferSize": 10}`},
+		{"c.yaml", "arrivalRate: 1.5\nbufferSize: 10\n"},
+		{"c.toml", "arrivalRate = 1.5\nbufferSize = 10\n"},
+	} {
+		tt := tt
+		t.Run("Config file values override the defaults for "+tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.name, tt.content)
+			cfg, err := config.Load("test", []string{"-config", path})
+			require.NoError(t, err)
+			assert.Equal(t, 1.5, cfg.ArrivalRate)
+			assert.Equal(t, 10, cfg.BufferSize)
+			assert.Equal(t, config.Default().OrdersFile, cfg.OrdersFile)
+			assert.Equal(t, config.Default().Stations, cfg.Stations)
+		})
+	}
+	for _, tt := range []struct{ name, content string }{
+		{"c.json", `{"stations": {"hot": 2}}`},
+		{"c.yaml", "stations:\n  hot: 2\n"},
+		{"c.toml", "[stations]\nhot = 2\n"},
+	} {
+		tt := tt
+		t.Run("Config file stations replace the default stations for "+tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.name, tt.content)
+			cfg, err := config.Load("test", []string{"-config", path})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]int{"hot": 2}, cfg.Stations)
+		})
+	}
+	t.Run("Flags override the config file regardless of their position", func(t *testing.T) {
+		path := writeConfig(t, "c.json", `{"arrivalRate": 1.5, "bufferSize": 10}`)
+		cfg, err := config.Load("test", []string{"-rate", "7", "-config", path})
+		require.NoError(t, err)
+		assert.Equal(t, 7.0, cfg.ArrivalRate)
+		assert.Equal(t, 10, cfg.BufferSize)
+	})
+	for _, tt := range []struct{ name, content string }{
+		{"c.json", `{"arrivalRat": 1.5}`},
+		{"c.yaml", "arrivalRat: 1.5\n"},
+		{"c.toml", "arrivalRat = 1.5\n"},
+	} {
+		tt := tt
+		t.Run("Unknown config file keys are rejected for "+tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.name, tt.content)
+			_, err := config.Load("test", []string{"-config", path})
+			assert.Error(t, err)
+		})
+	}
+	t.Run("Unsupported config file extensions are rejected", func(t *testing.T) {
+		path := writeConfig(t, "c.ini", "arrivalRate=1.5")
+		_, err := config.Load("test", []string{"-config", path})
+		assert.Error(t, err)
+	})
+	t.Run("Invalid values are rejected", func(t *testing.T) {
+		_, err := config.Load("test", []string{"-pickup-min", "5", "-pickup-max", "3"})
+		assert.EqualError(t, err, "pickup maximum (3) must not be less than the minimum (5)")
+		_, err = config.Load("test", []string{"-couriers", "0"})
+		assert.EqualError(t, err, "there must be at least 1 courier, got 0")
+		_, err = config.Load("test", []string{"-stations", "hot=0"})
+		assert.EqualError(t, err, "there must be at least 1 cooking station for hot orders, got 0")
+		_, err = config.Load("test", []string{"-clock", "sim", "-bus", "nats://localhost:4222"})
+		assert.EqualError(t, err, `the "sim" clock needs the "local" bus`)
+	})
+}
@@ -0,0 +1,53 @@
+package eventlog
+
+// The eventlog package records every event that flows through pub/sub to a newline delimited json log, and replays
+// recorded logs, so that incidents can be reproduced and shelf changes tested against real traffic.  Each line of the
+// log is a common.Envelope.
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"stream-first/common"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	serviceName = "EventLog"
+)
+
+// Record appends every event to w until ctx is done.
+func Record(ctx context.Context, ps common.PubsubInterface, w io.Writer) {
+	// A single subscription keeps the events in publishing order.
+	ch := ps.Sub(common.AllTopics...)
+	common.Diag(ps, serviceName, common.Info, "Recording started.", nil)
+	Record0(ctx, ps, ch, w)
+}
+
+// Record0 is a testable version of the recorder.  It allows injecting a mock for pub/sub and the event channel.  Once
+// ctx is done, the events already received are recorded before it returns.
+func Record0(ctx context.Context, ps common.PubsubInterface, ch chan interface{}, w io.Writer) {
+	encoder := json.NewEncoder(w)
+	failed := false
+	record := func(msg interface{}) {
+		envelope, err := common.NewEnvelope(msg)
+		if err == nil {
+			err = encoder.Encode(envelope)
+		}
+		// Report the first failure only, the recorder sees its own diagnostic messages.
+		if err != nil && !failed {
+			failed = true
+			common.Diag(ps, serviceName, common.Error, "", errors.Wrap(err, "recording"))
+		}
+	}
+	for {
+		select {
+		case msg := <-ch:
+			record(msg)
+		case <-ctx.Done():
+			common.Drain(ch, record)
+			return
+		}
+	}
+}
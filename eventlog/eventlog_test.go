@@ -0,0 +1,94 @@
+package eventlog_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"stream-first/common"
+	"stream-first/eventlog"
+	"stream-first/mocks"
+	"stream-first/ui/userrequests"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var testOrder = common.Order{ID: uuid.New(), Name: "an order", Temp: "hot", ShelfLife: 100, DecayRate: 1}
+
+func TestRecord0(t *testing.T) {
+	t.Run("Every event is recorded in an envelope, and decodes to what was published", func(t *testing.T) {
+		ps, ch, w := &mocks.MockPubsub{}, make(chan interface{}), &bytes.Buffer{}
+		ctx, stop := context.WithCancel(context.Background())
+		done := make(chan bool)
+		go func() {
+			eventlog.Record0(ctx, ps, ch, w)
+			done <- true
+		}()
+
+		dt := time.Now().UTC().Round(0)
+		events := []interface{}{
+			&common.NewOrderEvent{Dt: dt, Order: testOrder},
+			&common.ShelvedEvent{Dt: dt, Order: testOrder, Shelf: "hot"},
+			&common.ReshelvedEvent{Dt: dt, OrderID: testOrder.ID, Shelf: common.OverflowShelfName},
+			&common.ValueEvent{Dt: dt, Shelf: "hot", Value: 50, NormValue: 0.5, Order: testOrder},
+			&common.PickupEvent{Dt: dt, Order: testOrder},
+			&common.ExpiredEvent{Dt: dt, Order: testOrder},
+			&common.WasteEvent{Dt: dt, Order: testOrder, Shelf: "hot", Reason: common.WasteEvicted},
+			&common.UserRequestEvent{Dt: dt, Request: userrequests.SetArrivalRate, Rate: 2},
+		}
+		for _, e := range events {
+			ch <- e
+		}
+		ch <- &common.DiagEvent{Dt: dt, ServiceName: "Shelf", Severity: common.Error, Error: errors.New("broken")}
+		stop()
+		<-done
+
+		scanner := bufio.NewScanner(w)
+		var envelopes []common.Envelope
+		for scanner.Scan() {
+			var envelope common.Envelope
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &envelope), scanner.Text())
+			envelopes = append(envelopes, envelope)
+		}
+		require.Len(t, envelopes, len(events)+1)
+		for i, e := range events {
+			assert.Equal(t, e, envelopes[i].Payload)
+		}
+		assert.Equal(t, common.PickupTopic, envelopes[4].Topic())
+		assert.Equal(t, testOrder.ID, envelopes[4].CorrelationID)
+		// Errors are recorded as messages.
+		assert.Equal(t, &common.DiagEvent{Dt: dt, ServiceName: "Shelf", Severity: common.Error, Message: "broken"},
+			envelopes[len(events)].Payload)
+		ps.AssertNotCalled(t, "Pub", mock.Anything, mock.Anything)
+	})
+	t.Run("Unknown types are reported", func(t *testing.T) {
+		ps, ch := &mocks.MockPubsub{}, make(chan interface{})
+		ctx, stop := context.WithCancel(context.Background())
+		ps.On("Pub", mock.Anything, mock.Anything)
+		done := make(chan bool)
+		go func() {
+			eventlog.Record0(ctx, ps, ch, &bytes.Buffer{})
+			done <- true
+		}()
+		ch <- 42
+		stop()
+		<-done
+		ps.AssertNumberOfCalls(t, "Pub", 1)
+	})
+	t.Run("Events received before stopping are recorded", func(t *testing.T) {
+		ps, ch, w := &mocks.MockPubsub{}, make(chan interface{}, 2), &bytes.Buffer{}
+		ctx, stop := context.WithCancel(context.Background())
+		ch <- &common.NewOrderEvent{Order: testOrder}
+		ch <- &common.PickupEvent{Order: testOrder}
+		stop()
+		eventlog.Record0(ctx, ps, ch, w)
+		assert.Equal(t, 2, strings.Count(w.String(), "\n"))
+	})
+}
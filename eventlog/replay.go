@@ -0,0 +1,162 @@
+package eventlog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"stream-first/common"
+	"stream-first/shelflife"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Replay paces
+const (
+	// Events are replayed with the time between them that was recorded.
+	PaceOriginal = "original"
+	// Events are replayed as fast as the shelves take them.
+	PaceFast = "fast"
+)
+
+// How long to wait for the shelf to take a replayed order before moving on without knowing its shelf.
+const outcomeTimeout = 5 * time.Second
+
+// Replay feeds the new orders, cooked orders and pickups recorded in the log read from r back in, in place of the order
+// sender, the kitchen and the pickup service.  Everything else is left to the running services, so that their
+// decisions are made again.
+// Pickups of orders that are no longer on the shelves, because this time they were wasted or expired earlier, are
+// skipped, and so are orders with an invalid decay spec.  The replay stops early when ctx is done.
+func Replay(ctx context.Context, ps common.PubsubInterface, clock common.Clock, r io.Reader, pace string) {
+	// A single subscription keeps the outcomes in publishing order.
+	outcomeCh := ps.Sub(common.ShelvedTopic, common.ExpiredTopic, common.WasteTopic)
+	// Allow time for other components to subscribe before starting to publish.
+	time.Sleep(common.Seconds(common.SchedulerDelay))
+	common.Diag(ps, serviceName, common.Info, "Replay started.", nil)
+
+	stats, err := Replay0(ctx, ps, clock, r, pace, outcomeCh)
+	if err == context.Canceled {
+		common.Diag(ps, serviceName, common.Info, "Replay stopped.", nil)
+		return
+	}
+	if err != nil {
+		common.Diag(ps, serviceName, common.Error, "", errors.Wrap(err, "replay"))
+		return
+	}
+	common.Diag(ps, serviceName, common.Info, fmt.Sprintf(
+		"Replay done: %v orders, %v pickups, %v orders and %v pickups skipped.", stats.Orders, stats.Pickups,
+		stats.SkippedOrders, stats.SkippedPickups), nil)
+}
+
+// ReplayStats counts the replayed events.
+type ReplayStats struct {
+	Orders         int
+	Pickups        int
+	SkippedOrders  int
+	SkippedPickups int
+}
+
+// Replay0 is a testable version of the replay.  It allows injecting mocks for pub/sub and the clock, and the channel
+// that tells which orders were shelved, expired or wasted.  It returns once the whole log was replayed, or with
+// ctx.Err() once ctx is done.
+func Replay0(ctx context.Context, ps common.PubsubInterface, clock common.Clock, r io.Reader, pace string,
+	outcomeCh chan interface{}) (stats ReplayStats, err error) {
+	if pace != PaceOriginal && pace != PaceFast {
+		return stats, errors.Errorf("unknown pace %q, expected %q or %q", pace, PaceOriginal, PaceFast)
+	}
+
+	onShelves := map[uuid.UUID]bool{}
+	// Apply an outcome, and tell whether it is the outcome of the order with the given ID.
+	apply := func(msg interface{}, id uuid.UUID) bool {
+		switch e := msg.(type) {
+		case *common.ShelvedEvent:
+			onShelves[e.Order.ID] = true
+			return e.Order.ID == id
+		case *common.ExpiredEvent:
+			delete(onShelves, e.Order.ID)
+		case *common.WasteEvent:
+			delete(onShelves, e.Order.ID)
+			return e.Order.ID == id
+		default:
+			common.Diag(ps, serviceName, common.Error, common.CoerceErrorMessage(msg, e), nil)
+		}
+		return false
+	}
+
+	decoder := json.NewDecoder(r)
+	var firstDt, start time.Time
+	for line := 1; ; line++ {
+		var envelope common.Envelope
+		if err = decoder.Decode(&envelope); err == io.EOF {
+			return stats, nil
+		} else if err != nil {
+			return stats, errors.Wrapf(err, "line %v", line)
+		}
+		switch envelope.Topic() {
+		case common.NewOrderTopic, common.OrderCookedTopic, common.PickupTopic:
+		default:
+			continue
+		}
+
+		if firstDt.IsZero() {
+			firstDt, start = envelope.Dt, clock.Now()
+		}
+		if pace == PaceOriginal {
+			// Keep to the recorded schedule, regardless of the time spent waiting for the shelves.
+			if d := start.Add(envelope.Dt.Sub(firstDt)).Sub(clock.Now()); d > 0 {
+				select {
+				case <-clock.After(d):
+				case <-ctx.Done():
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+		// Catch up with the outcomes so far.
+		common.Drain(outcomeCh, func(msg interface{}) { apply(msg, uuid.Nil) })
+
+		switch e := envelope.Payload.(type) {
+		case *common.NewOrderEvent:
+			// The order would have no value on the shelves, see shelflife.OrderState.Value.
+			if _, err := shelflife.NewDecayModel(e.Order.Decay); err != nil {
+				common.Diag(ps, serviceName, common.Error, "",
+					errors.Wrapf(err, "replayed order %v skipped, invalid decay", e.Order.ID))
+				stats.SkippedOrders++
+				continue
+			}
+			ps.Pub(&common.NewOrderEvent{Dt: clock.Now(), Order: e.Order}, common.NewOrderTopic)
+			stats.Orders++
+		case *common.OrderCookedEvent:
+			if _, err := shelflife.NewDecayModel(e.Order.Decay); err != nil {
+				// Reported with the new order.
+				continue
+			}
+			ps.Pub(&common.OrderCookedEvent{Dt: clock.Now(), Order: e.Order}, common.OrderCookedTopic)
+			// Wait for the order to be shelved or wasted, so that its pickup isn't skipped.
+			timeoutCh := time.After(outcomeTimeout)
+			for waiting := true; waiting; {
+				select {
+				case msg := <-outcomeCh:
+					waiting = !apply(msg, e.Order.ID)
+				case <-timeoutCh:
+					common.Diag(ps, serviceName, common.Warning,
+						fmt.Sprintf("No shelf took replayed order %v.", e.Order.ID), nil)
+					waiting = false
+				case <-ctx.Done():
+					return stats, ctx.Err()
+				}
+			}
+		case *common.PickupEvent:
+			if !onShelves[e.Order.ID] {
+				stats.SkippedPickups++
+				continue
+			}
+			delete(onShelves, e.Order.ID)
+			ps.Pub(&common.PickupEvent{Dt: clock.Now(), Order: e.Order}, common.PickupTopic)
+			stats.Pickups++
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package eventlog_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"stream-first/common"
+	"stream-first/eventlog"
+	"stream-first/mocks"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// Write a log of the given events, recorded a second apart.
+func writeLog(t *testing.T, start time.Time, events ...interface{}) *bytes.Buffer {
+	w := &bytes.Buffer{}
+	encoder := json.NewEncoder(w)
+	for i, e := range events {
+		envelope, err := common.NewEnvelope(e)
+		require.NoError(t, err)
+		envelope.Dt = start.Add(time.Duration(i) * time.Second)
+		require.NoError(t, encoder.Encode(envelope))
+	}
+	return w
+}
+
+// A stand-in shelf that shelves hot orders once cooked and wastes all others.
+func standInShelf(ps *mocks.MockPubsub, outcomeCh chan interface{}) {
+	ps.On("Pub", mock.Anything, []string{common.OrderCookedTopic}).Run(func(args mock.Arguments) {
+		e := args.Get(0).(*common.OrderCookedEvent)
+		if e.Order.Temp == "hot" {
+			outcomeCh <- &common.ShelvedEvent{Dt: e.Dt, Order: e.Order, Shelf: "hot"}
+		} else {
+			outcomeCh <- &common.WasteEvent{Dt: e.Dt, Order: e.Order, Reason: common.WasteShelvesFull}
+		}
+	})
+	ps.On("Pub", mock.Anything, mock.Anything)
+}
+
+func TestReplay0(t *testing.T) {
+	wasted := common.Order{ID: uuid.New(), Name: "wasted", Temp: "cold", ShelfLife: 100, DecayRate: 1}
+	recorded := time.Now().Add(-time.Hour)
+	events := []interface{}{
+		&common.NewOrderEvent{Dt: recorded, Order: testOrder},
+		&common.OrderCookedEvent{Dt: recorded, Order: testOrder},
+		&common.ShelvedEvent{Dt: recorded, Order: testOrder, Shelf: "hot"},
+		&common.NewOrderEvent{Dt: recorded, Order: wasted},
+		&common.OrderCookedEvent{Dt: recorded, Order: wasted},
+		&common.PickupEvent{Dt: recorded, Order: testOrder},
+		// Made it to the shelf in the recording, but not in the replay.
+		&common.PickupEvent{Dt: recorded, Order: wasted},
+	}
+
+	t.Run("Fast replay publishes orders, and pickups of orders on the shelves, restamped", func(t *testing.T) {
+		ps, outcomeCh := &mocks.MockPubsub{}, make(chan interface{}, 10)
+		standInShelf(ps, outcomeCh)
+		clock := common.NewSimClock(time.Now())
+
+		stats, err := eventlog.Replay0(context.Background(), ps, clock, writeLog(t, recorded, events...),
+			eventlog.PaceFast, outcomeCh)
+		require.NoError(t, err)
+		assert.Equal(t, eventlog.ReplayStats{Orders: 2, Pickups: 1, SkippedPickups: 1}, stats)
+		ps.AssertCalled(t, "Pub", &common.NewOrderEvent{Dt: clock.Now(), Order: testOrder}, []string{common.NewOrderTopic})
+		ps.AssertCalled(t, "Pub", &common.NewOrderEvent{Dt: clock.Now(), Order: wasted}, []string{common.NewOrderTopic})
+		ps.AssertCalled(t, "Pub", &common.OrderCookedEvent{Dt: clock.Now(), Order: testOrder},
+			[]string{common.OrderCookedTopic})
+		ps.AssertCalled(t, "Pub", &common.PickupEvent{Dt: clock.Now(), Order: testOrder}, []string{common.PickupTopic})
+		ps.AssertNotCalled(t, "Pub", &common.PickupEvent{Dt: clock.Now(), Order: wasted}, []string{common.PickupTopic})
+		ps.AssertNotCalled(t, "Pub", mock.Anything, []string{common.ShelvedTopic})
+	})
+	t.Run("Original pace keeps the recorded time between events", func(t *testing.T) {
+		ps, outcomeCh := &mocks.MockPubsub{}, make(chan interface{}, 10)
+		standInShelf(ps, outcomeCh)
+		start := time.Now()
+		clock := common.NewSimClock(start)
+
+		done := make(chan eventlog.ReplayStats)
+		go func() {
+			stats, err := eventlog.Replay0(context.Background(), ps, clock, writeLog(t, recorded, events...),
+				eventlog.PaceOriginal, outcomeCh)
+			assert.NoError(t, err)
+			done <- stats
+		}()
+		// Events are a second apart, the shelved event is not replayed.
+		for i := 0; i < 6; i++ {
+			clock.BlockUntil(1)
+			clock.Advance(time.Second)
+		}
+		assert.Equal(t, eventlog.ReplayStats{Orders: 2, Pickups: 1, SkippedPickups: 1}, <-done)
+		ps.AssertCalled(t, "Pub", &common.NewOrderEvent{Dt: start, Order: testOrder}, []string{common.NewOrderTopic})
+		ps.AssertCalled(t, "Pub", &common.OrderCookedEvent{Dt: start.Add(time.Second), Order: testOrder},
+			[]string{common.OrderCookedTopic})
+		ps.AssertCalled(t, "Pub", &common.NewOrderEvent{Dt: start.Add(3 * time.Second), Order: wasted},
+			[]string{common.NewOrderTopic})
+		ps.AssertCalled(t, "Pub", &common.PickupEvent{Dt: start.Add(5 * time.Second), Order: testOrder},
+			[]string{common.PickupTopic})
+	})
+	t.Run("Stopping ends the replay early", func(t *testing.T) {
+		ps, outcomeCh := &mocks.MockPubsub{}, make(chan interface{}, 10)
+		standInShelf(ps, outcomeCh)
+		clock := common.NewSimClock(time.Now())
+		ctx, stop := context.WithCancel(context.Background())
+
+		type result struct {
+			stats eventlog.ReplayStats
+			err   error
+		}
+		done := make(chan result)
+		go func() {
+			stats, err := eventlog.Replay0(ctx, ps, clock, writeLog(t, recorded, events...), eventlog.PaceOriginal,
+				outcomeCh)
+			done <- result{stats, err}
+		}()
+		// Waiting for the first order to be cooked.
+		clock.BlockUntil(1)
+		stop()
+		got := <-done
+		assert.Equal(t, context.Canceled, got.err)
+		assert.Equal(t, eventlog.ReplayStats{Orders: 1}, got.stats)
+	})
+	t.Run("Orders with an invalid decay spec are skipped", func(t *testing.T) {
+		invalid := testOrder
+		invalid.ID = uuid.New()
+		invalid.Decay = &common.DecaySpec{Model: "soggy"}
+		ps, outcomeCh := &mocks.MockPubsub{}, make(chan interface{}, 10)
+		standInShelf(ps, outcomeCh)
+
+		stats, err := eventlog.Replay0(context.Background(), ps, common.NewSimClock(time.Now()),
+			writeLog(t, recorded, &common.NewOrderEvent{Dt: recorded, Order: invalid},
+				&common.OrderCookedEvent{Dt: recorded, Order: invalid}, &common.PickupEvent{Dt: recorded, Order: invalid}),
+			eventlog.PaceFast, outcomeCh)
+		require.NoError(t, err)
+		assert.Equal(t, eventlog.ReplayStats{SkippedOrders: 1, SkippedPickups: 1}, stats)
+		ps.AssertNotCalled(t, "Pub", mock.Anything, []string{common.NewOrderTopic})
+		ps.AssertNotCalled(t, "Pub", mock.Anything, []string{common.OrderCookedTopic})
+		ps.AssertCalled(t, "Pub", mock.AnythingOfType("*common.DiagEvent"), []string{common.DiagTopic})
+	})
+	t.Run("Malformed logs and unknown paces are errors", func(t *testing.T) {
+		ps := &mocks.MockPubsub{}
+		_, err := eventlog.Replay0(context.Background(), ps, common.NewSimClock(time.Now()),
+			bytes.NewBufferString("{not json"), eventlog.PaceFast, nil)
+		assert.Error(t, err)
+		_, err = eventlog.Replay0(context.Background(), ps, common.NewSimClock(time.Now()), &bytes.Buffer{}, "slow", nil)
+		assert.Error(t, err)
+	})
+}
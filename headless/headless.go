@@ -0,0 +1,120 @@
+package headless
+
+// The headless package replaces the terminal UI for runs in containers, CI jobs or pipelines.  It logs diagnostics
+// to stderr as structured lines, and ends the run after a number of orders or a duration, or when the user asks to
+// quit through the control API.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"stream-first/common"
+	"stream-first/ui/userrequests"
+	"time"
+)
+
+const (
+	serviceName = "Headless"
+)
+
+// Process exit codes
+const (
+	ExitOK    = 0
+	ExitError = 1
+)
+
+// Run logs diagnostics until the run ends, and returns the process exit code.  The run ends once maxOrders orders
+// were received and none of them remain on the shelves, or after duration, whichever comes first.  A zero value
+// disables the respective limit.  A quit user request, or ctx being done, ends the run right away.  The exit code is
+// ExitError if any service reported an error.
+func Run(ctx context.Context, ps common.PubsubInterface, clock common.Clock, maxOrders int,
+	duration time.Duration) int {
+	// A single subscription keeps events in publishing order, so an order is always seen before its shelving.
+	ch := ps.Sub(common.NewOrderTopic, common.ShelvedTopic, common.PickupTopic, common.ExpiredTopic, common.WasteTopic,
+		common.DiagTopic, common.UserRequestTopic)
+	return Run0(ctx, ps, clock, ch, maxOrders, duration, os.Stderr)
+}
+
+// Run0 is a testable version of the service.  It allows injecting the clock, the event channel and the log writer.
+func Run0(ctx context.Context, ps common.PubsubInterface, clock common.Clock, ch chan interface{}, maxOrders int,
+	duration time.Duration, w io.Writer) int {
+	var timeoutCh <-chan time.Time
+	if duration > 0 {
+		timeoutCh = clock.After(duration)
+	}
+
+	exitCode := ExitOK
+	// received counts new orders, disposed counts those that were either shelved or wasted for lack of space,
+	// and onShelves counts those that were shelved and not yet picked up or expired.
+	var received, disposed, onShelves int
+	for {
+		select {
+		case msg := <-ch:
+			switch e := msg.(type) {
+			case *common.NewOrderEvent:
+				received++
+			case *common.ShelvedEvent:
+				disposed++
+				onShelves++
+			case *common.PickupEvent, *common.ExpiredEvent:
+				onShelves--
+			case *common.WasteEvent:
+				// Expired orders are accounted for by their expired event.
+				switch e.Reason {
+				case common.WasteShelvesFull:
+					disposed++
+				case common.WasteEvicted:
+					onShelves--
+				}
+			case *common.UserRequestEvent:
+				if e.Request == userrequests.QuitRequest {
+					log(w, &common.DiagEvent{Dt: clock.Now(), ServiceName: serviceName, Severity: common.Info,
+						Message: "Quit requested."})
+					return exitCode
+				}
+			case *common.DiagEvent:
+				log(w, e)
+				if e.Severity == common.Error {
+					exitCode = ExitError
+				}
+			default:
+				log(w, &common.DiagEvent{Dt: clock.Now(), ServiceName: serviceName, Severity: common.Error,
+					Message: common.CoerceErrorMessage(msg, e)})
+				exitCode = ExitError
+			}
+			if maxOrders > 0 && received >= maxOrders && disposed >= received && onShelves <= 0 {
+				log(w, &common.DiagEvent{Dt: clock.Now(), ServiceName: serviceName, Severity: common.Info,
+					Message: fmt.Sprintf("All %v orders resolved.", received)})
+				return exitCode
+			}
+		case <-timeoutCh:
+			log(w, &common.DiagEvent{Dt: clock.Now(), ServiceName: serviceName, Severity: common.Info,
+				Message: fmt.Sprintf("Run ended after %v.", duration)})
+			return exitCode
+		case <-ctx.Done():
+			// Log the diagnostic messages already received.
+			common.Drain(ch, func(msg interface{}) {
+				if e, ok := msg.(*common.DiagEvent); ok {
+					log(w, e)
+					if e.Severity == common.Error {
+						exitCode = ExitError
+					}
+				}
+			})
+			log(w, &common.DiagEvent{Dt: clock.Now(), ServiceName: serviceName, Severity: common.Info,
+				Message: "Run stopped."})
+			return exitCode
+		}
+	}
+}
+
+// log writes a diagnostic message as a single logfmt line.
+func log(w io.Writer, e *common.DiagEvent) {
+	message := e.Message
+	if e.Error != nil {
+		message = fmt.Sprint(e.Error)
+	}
+	_, _ = fmt.Fprintf(w, "time=%v severity=%v service=%q message=%q\n",
+		e.Dt.Format(time.RFC3339Nano), e.Severity, e.ServiceName, message)
+}
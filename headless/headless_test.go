@@ -0,0 +1,79 @@
+package headless_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"stream-first/common"
+	"stream-first/headless"
+	"stream-first/mocks"
+	"stream-first/ui/userrequests"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testOrder = common.Order{ID: uuid.New(), Name: "an order", Temp: "hot", ShelfLife: 100, DecayRate: 1}
+
+func TestRun0(t *testing.T) {
+	t.Run("Run ends once all orders are resolved", func(t *testing.T) {
+		ps, ch, w := &mocks.MockPubsub{}, make(chan interface{}), &bytes.Buffer{}
+		done := make(chan int)
+		go func() { done <- headless.Run0(context.Background(), ps, common.NewSimClock(time.Now()), ch, 2, 0, w) }()
+
+		wasted := common.Order{ID: uuid.New()}
+		ch <- &common.NewOrderEvent{Order: testOrder}
+		ch <- &common.ShelvedEvent{Order: testOrder, Shelf: "hot"}
+		ch <- &common.NewOrderEvent{Order: wasted}
+		ch <- &common.WasteEvent{Order: wasted, Reason: common.WasteShelvesFull}
+		ch <- &common.DiagEvent{ServiceName: "Shelf", Severity: common.Warning,
+			Message: fmt.Sprintf("Waste - shelves full: %+v", wasted)}
+		select {
+		case <-done:
+			t.Fatal("run ended while an order was still on the shelves")
+		case <-time.After(common.Seconds(common.SchedulerDelay)):
+		}
+		ch <- &common.PickupEvent{Order: testOrder}
+		require.Equal(t, headless.ExitOK, <-done)
+		assert.Contains(t, w.String(), `severity=WARN service="Shelf" message="Waste - shelves full`)
+	})
+	t.Run("Run ends after the duration and reports errors in the exit code", func(t *testing.T) {
+		ps, ch, w := &mocks.MockPubsub{}, make(chan interface{}), &bytes.Buffer{}
+		clock := common.NewSimClock(time.Now())
+		done := make(chan int)
+		go func() { done <- headless.Run0(context.Background(), ps, clock, ch, 0, time.Hour, w) }()
+
+		ch <- &common.DiagEvent{ServiceName: "Shelf", Severity: common.Error, Message: "something broke"}
+		clock.BlockUntil(1)
+		clock.Advance(time.Hour)
+		require.Equal(t, headless.ExitError, <-done)
+		assert.Contains(t, w.String(), `severity=ERROR service="Shelf" message="something broke"`)
+	})
+	t.Run("Run ends when the user asks to quit", func(t *testing.T) {
+		ps, ch, w := &mocks.MockPubsub{}, make(chan interface{}), &bytes.Buffer{}
+		done := make(chan int)
+		go func() {
+			done <- headless.Run0(context.Background(), ps, common.NewSimClock(time.Now()), ch, 0, time.Hour, w)
+		}()
+
+		ch <- &common.NewOrderEvent{Order: testOrder}
+		ch <- &common.UserRequestEvent{Request: userrequests.PausePickup}
+		ch <- &common.UserRequestEvent{Request: userrequests.SetArrivalRate, Rate: 10}
+		ch <- &common.UserRequestEvent{Request: userrequests.QuitRequest}
+		require.Equal(t, headless.ExitOK, <-done)
+		assert.Contains(t, w.String(), `message="Quit requested."`)
+	})
+	t.Run("Run ends when stopped, after logging the messages already received", func(t *testing.T) {
+		ps, ch, w := &mocks.MockPubsub{}, make(chan interface{}, 1), &bytes.Buffer{}
+		ctx, stop := context.WithCancel(context.Background())
+		ch <- &common.DiagEvent{ServiceName: "Shelf", Severity: common.Error, Message: "something broke"}
+		stop()
+		require.Equal(t, headless.ExitError,
+			headless.Run0(ctx, ps, common.NewSimClock(time.Now()), ch, 0, time.Hour, w))
+		assert.Contains(t, w.String(), `severity=ERROR service="Shelf" message="something broke"`)
+		assert.Contains(t, w.String(), `message="Run stopped."`)
+	})
+}
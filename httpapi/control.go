@@ -0,0 +1,147 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"stream-first/common"
+	"stream-first/ui/userrequests"
+
+	"github.com/pkg/errors"
+)
+
+// ControlRequest is the body of POST /control/requests.
+type ControlRequest struct {
+	// One of the pause, resume and quit requests of the userrequests package.
+	Request string `json:"request"`
+}
+
+// ArrivalRate is the body of POST /control/arrival-rate.
+type ArrivalRate struct {
+	// Mean number of new orders per second.
+	Rate float64 `json:"rate"`
+}
+
+type controlHandler struct {
+	controller *userrequests.Controller
+	layout     common.ShelfLayout
+	mux        *http.ServeMux
+}
+
+// NewControlHandler returns the handler of the control API, which passes requests to controller:
+//
+//	GET  /control               returns the requested state, a userrequests.State.
+//	POST /control/requests      makes a ControlRequest, and returns the new state.
+//	POST /control/arrival-rate  changes the arrival rate to ArrivalRate, and returns the new state.
+//	POST /control/orders        injects a single order, and returns its OrderResult without waiting for the shelf.
+func NewControlHandler(controller *userrequests.Controller, layout common.ShelfLayout) http.Handler {
+	h := &controlHandler{controller: controller, layout: layout, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/control", h.handleState)
+	h.mux.HandleFunc("/control/requests", h.handleRequest)
+	h.mux.HandleFunc("/control/arrival-rate", h.handleArrivalRate)
+	h.mux.HandleFunc("/control/orders", h.handleOrder)
+	return h
+}
+
+func (h *controlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// RunControlSocket serves the control API on a Unix socket at path until ctx is done or the server fails.  A socket
+// left behind by an earlier run is replaced.
+func RunControlSocket(ctx context.Context, ps common.PubsubInterface, controller *userrequests.Controller,
+	layout common.ShelfLayout, path string) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		common.Diag(ps, serviceName, common.Error, "", errors.Wrap(err, "control socket"))
+		return
+	}
+	defer func() { _ = os.Remove(path) }()
+
+	common.Diag(ps, serviceName, common.Info, "Control API started on "+path+".", nil)
+	if err := serve(ctx, NewControlHandler(controller, layout), listener); err != nil {
+		common.Diag(ps, serviceName, common.Error, "", errors.Wrap(err, "control socket"))
+	}
+}
+
+func (h *controlHandler) handleState(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.controller.State())
+}
+
+func (h *controlHandler) handleRequest(w http.ResponseWriter, r *http.Request) {
+	var request ControlRequest
+	if !decodePost(w, r, &request) {
+		return
+	}
+	state, err := h.controller.Request(request.Request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+func (h *controlHandler) handleArrivalRate(w http.ResponseWriter, r *http.Request) {
+	var rate ArrivalRate
+	if !decodePost(w, r, &rate) {
+		return
+	}
+	state, err := h.controller.SetArrivalRate(rate.Rate)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+func (h *controlHandler) handleOrder(w http.ResponseWriter, r *http.Request) {
+	var order common.Order
+	if !decodePost(w, r, &order) {
+		return
+	}
+	if err := validateOrder(h.layout, order); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	order = h.controller.InjectOrder(order)
+	writeJSON(w, http.StatusAccepted, OrderResult{ID: order.ID})
+}
+
+// Decode the json body of a POST request into v.  An error response is written if the request can't be decoded.
+func decodePost(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	if !allowJSON(w, r) {
+		return false
+	}
+	err := json.NewDecoder(io.LimitReader(r.Body, maxOrdersBodyBytes)).Decode(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// Reject request bodies that are not json.  Browsers send form posts to other sites without asking them first, but
+// not json ones, so this keeps web pages from making requests on the user's behalf.
+func allowJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "content type must be application/json")
+		return false
+	}
+	return true
+}
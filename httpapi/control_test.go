@@ -0,0 +1,112 @@
+package httpapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"stream-first/common"
+	"stream-first/httpapi"
+	"stream-first/ui/userrequests"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cskr/pubsub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_control(t *testing.T) {
+	t.Run("Requests change the state, which is returned", func(t *testing.T) {
+		s, _ := newServer()
+		var state userrequests.State
+		require.Equal(t, http.StatusOK, get(s, "/control", &state).Code)
+		assert.Equal(t, userrequests.State{ArrivalRate: 3.25}, state)
+
+		w := post(s, "/control/requests", `{"request": "pausePickup"}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.JSONEq(t, `{"pickupPaused": true, "incomingOrdersPaused": false, "arrivalRate": 3.25}`, w.Body.String())
+		w = post(s, "/control/arrival-rate", `{"rate": 10}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.JSONEq(t, `{"pickupPaused": true, "incomingOrdersPaused": false, "arrivalRate": 10}`, w.Body.String())
+		assert.Equal(t, http.StatusOK, post(s, "/control/requests", `{"request": "quit"}`).Code)
+	})
+	t.Run("Invalid requests are rejected", func(t *testing.T) {
+		s, _ := newServer()
+		for path, body := range map[string]string{
+			"/control/requests":     `{"request": "dance"}`,
+			"/control/arrival-rate": `{"rate": 0}`,
+			"/control/orders":       `{"name": "Pizza", "temp": "lukewarm", "shelfLife": 300, "decayRate": 0.45}`,
+		} {
+			assert.Equal(t, http.StatusBadRequest, post(s, path, body).Code, path)
+		}
+		assert.Equal(t, http.StatusMethodNotAllowed, get(s, "/control/requests", nil).Code)
+	})
+	t.Run("Bodies other than json are rejected", func(t *testing.T) {
+		s, _ := newServer()
+		for _, path := range []string{"/control/requests", "/control/arrival-rate", "/control/orders", "/orders"} {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"request": "quit"}`))
+			r.Header.Set("Content-Type", "text/plain")
+			s.ServeHTTP(w, r)
+			assert.Equal(t, http.StatusUnsupportedMediaType, w.Code, path)
+		}
+		var state userrequests.State
+		require.Equal(t, http.StatusOK, get(s, "/control", &state).Code)
+		assert.Equal(t, userrequests.State{ArrivalRate: 3.25}, state)
+	})
+	t.Run("An injected order is published without waiting for its shelf", func(t *testing.T) {
+		s, newOrders := newServer()
+		w := post(s, "/control/orders", `{"name": "Pizza", "temp": "hot", "shelfLife": 300, "decayRate": 0.45}`)
+		require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+		var result httpapi.OrderResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, common.Order{ID: result.ID, Name: "Pizza", Temp: "hot", ShelfLife: 300, DecayRate: 0.45},
+			<-newOrders)
+	})
+}
+
+func TestRunControlSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "control")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "control.sock")
+
+	ps := pubsub.New(100)
+	controller := userrequests.NewController(ps, common.NewSimClock(time.Now()), 3.25)
+	_, _ = controller.Request(userrequests.PauseIncomingOrders)
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		httpapi.RunControlSocket(ctx, ps, controller, common.DefaultShelfLayout, path)
+		done <- true
+	}()
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	var response *http.Response
+	require.Eventually(t, func() bool {
+		response, err = client.Get("http://control/control")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	defer func() { _ = response.Body.Close() }()
+
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	var state userrequests.State
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&state))
+	assert.Equal(t, userrequests.State{IncomingOrdersPaused: true, ArrivalRate: 3.25}, state)
+
+	// Stopping the server removes the socket.
+	stop()
+	<-done
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
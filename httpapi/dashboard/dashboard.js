@@ -0,0 +1,178 @@
+// Live view of the shelves, like the terminal screen.  The initial state is fetched from the query API, and kept up
+// to date from the event stream.
+"use strict";
+
+const maxDiagnostics = 200;
+
+// Shelf name -> {capacity, tiles element, count element}
+const shelves = new Map();
+// Order ID -> tile element
+const tiles = new Map();
+
+// Green when fresh, red when about to expire.
+function valueColor(normValue) {
+  const hue = Math.max(0, Math.min(1, normValue)) * 120;
+  return `hsl(${hue}, 70%, 50%)`;
+}
+
+function updateCount(name) {
+  const shelf = shelves.get(name);
+  if (shelf) {
+    shelf.count.textContent = `${shelf.tiles.childElementCount}/${shelf.capacity}`;
+  }
+}
+
+function renderShelves(statuses) {
+  const main = document.getElementById("shelves");
+  main.replaceChildren();
+  shelves.clear();
+  tiles.clear();
+  for (const status of statuses) {
+    const box = document.createElement("div");
+    box.className = "shelf";
+    const title = document.createElement("h2");
+    const count = document.createElement("span");
+    title.append(`${status.name} `, count);
+    const tilesElement = document.createElement("div");
+    tilesElement.className = "tiles";
+    tilesElement.dataset.shelf = status.name;
+    box.append(title, tilesElement);
+    main.append(box);
+    shelves.set(status.name, {capacity: status.capacity, tiles: tilesElement, count: count});
+    updateCount(status.name);
+  }
+}
+
+// Show the order on its shelf with its current value, moving it if it changed shelves.
+function showOrder(id, name, shelfName, value, normValue) {
+  const shelf = shelves.get(shelfName);
+  if (!shelf) {
+    return;
+  }
+  let tile = tiles.get(id);
+  if (!tile) {
+    tile = document.createElement("div");
+    tile.className = "tile";
+    tiles.set(id, tile);
+  }
+  const previous = tile.parentElement;
+  if (previous !== shelf.tiles) {
+    shelf.tiles.append(tile);
+    if (previous) {
+      updateCount(previous.dataset.shelf);
+    }
+    updateCount(shelfName);
+  }
+  tile.textContent = `${name} ${value.toFixed(0)}`;
+  tile.title = `${name}\n${id}\nvalue ${value.toFixed(1)} (${(normValue * 100).toFixed(0)}%)`;
+  tile.style.background = valueColor(normValue);
+}
+
+function removeOrder(id) {
+  const tile = tiles.get(id);
+  if (!tile) {
+    return;
+  }
+  const shelf = tile.parentElement;
+  tile.remove();
+  tiles.delete(id);
+  if (shelf) {
+    updateCount(shelf.dataset.shelf);
+  }
+}
+
+function addDiagnostic(e) {
+  const feed = document.getElementById("diag-feed");
+  const atBottom = feed.scrollTop + feed.clientHeight >= feed.scrollHeight - 5;
+  const item = document.createElement("li");
+  item.className = `severity-${e.Severity}`;
+  item.textContent = `${new Date(e.Dt).toLocaleTimeString()} ${e.Severity} ${e.ServiceName}: ${e.Message}`;
+  feed.append(item);
+  while (feed.childElementCount > maxDiagnostics) {
+    feed.firstElementChild.remove();
+  }
+  if (atBottom) {
+    feed.scrollTop = feed.scrollHeight;
+  }
+}
+
+function showControlState(state) {
+  showToggle(document.getElementById("toggle-pickup"), "Pickup", state.pickupPaused);
+  showToggle(document.getElementById("toggle-incoming"), "Incoming Orders", state.incomingOrdersPaused);
+  const rate = document.getElementById("arrival-rate");
+  // Don't overwrite a rate that is being typed in.
+  if (document.activeElement !== rate) {
+    rate.value = state.arrivalRate;
+  }
+}
+
+function showToggle(button, label, paused) {
+  button.dataset.paused = paused;
+  button.classList.toggle("paused", paused);
+  button.textContent = `${label} (${paused ? "Paused" : "Running"})`;
+}
+
+async function fetchJSON(path, options) {
+  const response = await fetch(path, options);
+  if (!response.ok) {
+    throw new Error(`${path}: ${response.status}`);
+  }
+  return response.json();
+}
+
+function postJSON(path, body) {
+  return fetchJSON(path, {method: "POST", headers: {"Content-Type": "application/json"}, body: JSON.stringify(body)});
+}
+
+async function toggle(button) {
+  const request = button.dataset.paused === "true" ? button.dataset.resume : button.dataset.pause;
+  showControlState(await postJSON("control/requests", {request: request}));
+}
+
+async function setArrivalRate(input) {
+  const rate = parseFloat(input.value);
+  showControlState(await postJSON("control/arrival-rate", {rate: rate}));
+}
+
+async function load() {
+  renderShelves(await fetchJSON("shelves"));
+  for (const order of await fetchJSON("orders")) {
+    showOrder(order.id, order.name, order.shelf, order.value, order.normValue);
+  }
+  showControlState(await fetchJSON("control"));
+}
+
+function connect() {
+  const connection = document.getElementById("connection");
+  const source = new EventSource("events?topics=value,pickup,expired,waste,diag,keyboard");
+  source.onopen = () => {
+    connection.textContent = "Connected";
+    connection.className = "connected";
+    // Catch up with whatever happened while disconnected.
+    load().catch(err => addDiagnostic({Dt: Date.now(), Severity: "ERROR", ServiceName: "Dashboard", Message: err.message}));
+  };
+  source.onerror = () => {
+    connection.textContent = "Disconnected";
+    connection.className = "disconnected";
+  };
+  source.addEventListener("value", m => {
+    const e = JSON.parse(m.data).payload;
+    showOrder(e.Order.ID, e.Order.name, e.Shelf, e.Value, e.NormValue);
+  });
+  for (const topic of ["pickup", "expired", "waste"]) {
+    source.addEventListener(topic, m => removeOrder(JSON.parse(m.data).payload.Order.ID));
+  }
+  source.addEventListener("diag", m => addDiagnostic(JSON.parse(m.data).payload));
+  source.addEventListener("keyboard", () => fetchJSON("control").then(showControlState));
+  source.addEventListener("dropped", m => addDiagnostic({
+    Dt: Date.now(), Severity: "WARN", ServiceName: "Dashboard",
+    Message: `Fell behind, ${JSON.parse(m.data).dropped} events dropped.`,
+  }));
+}
+
+for (const button of document.querySelectorAll("#controls button")) {
+  button.addEventListener("click", () => toggle(button));
+}
+document.getElementById("arrival-rate").addEventListener("change", e => setArrivalRate(e.target).catch(err =>
+  addDiagnostic({Dt: Date.now(), Severity: "ERROR", ServiceName: "Dashboard", Message: err.message})));
+connect();
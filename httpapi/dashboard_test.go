@@ -0,0 +1,24 @@
+package httpapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_dashboard(t *testing.T) {
+	s, _ := newServer()
+	for path, contentType := range map[string]string{
+		"/":              "text/html",
+		"/dashboard.js":  "javascript",
+		"/dashboard.css": "text/css",
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusOK, w.Code, path)
+		assert.Contains(t, w.Header().Get("Content-Type"), contentType, path)
+	}
+}
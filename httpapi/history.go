@@ -0,0 +1,83 @@
+package httpapi
+
+import (
+	"stream-first/common"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The history of this many of the most recent orders is kept.
+const maxHistoryOrders = 1000
+
+// HistoryEntry records an event in the life of an order.
+type HistoryEntry struct {
+	Dt time.Time `json:"dt"`
+	// The topic of the event.
+	Event string `json:"event"`
+	Shelf string `json:"shelf,omitempty"`
+	// The waste reason, for waste events.
+	Reason string `json:"reason,omitempty"`
+}
+
+// The events of recent orders.
+type history struct {
+	mu      sync.Mutex
+	orders  map[uuid.UUID]common.Order
+	entries map[uuid.UUID][]HistoryEntry
+	// Order IDs, oldest first, for dropping the oldest orders.
+	orderIDs []uuid.UUID
+}
+
+func newHistory() *history {
+	return &history{orders: map[uuid.UUID]common.Order{}, entries: map[uuid.UUID][]HistoryEntry{}}
+}
+
+// Record the event if it is about an order.  Reshelved events are only recorded for known orders.
+func (h *history) record(msg interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch e := msg.(type) {
+	case *common.NewOrderEvent:
+		h.add(e.Order, HistoryEntry{Dt: e.Dt, Event: common.NewOrderTopic})
+	case *common.OrderCookedEvent:
+		h.add(e.Order, HistoryEntry{Dt: e.Dt, Event: common.OrderCookedTopic})
+	case *common.ShelvedEvent:
+		h.add(e.Order, HistoryEntry{Dt: e.Dt, Event: common.ShelvedTopic, Shelf: e.Shelf})
+	case *common.ReshelvedEvent:
+		if order, ok := h.orders[e.OrderID]; ok {
+			h.add(order, HistoryEntry{Dt: e.Dt, Event: common.ReshelvedTopic, Shelf: e.Shelf})
+		}
+	case *common.PickupEvent:
+		h.add(e.Order, HistoryEntry{Dt: e.Dt, Event: common.PickupTopic})
+	case *common.ExpiredEvent:
+		h.add(e.Order, HistoryEntry{Dt: e.Dt, Event: common.ExpiredTopic})
+	case *common.WasteEvent:
+		h.add(e.Order, HistoryEntry{Dt: e.Dt, Event: common.WasteTopic, Shelf: e.Shelf, Reason: e.Reason})
+	}
+}
+
+// Called with the lock held.
+func (h *history) add(order common.Order, entry HistoryEntry) {
+	if _, ok := h.orders[order.ID]; !ok {
+		if len(h.orderIDs) >= maxHistoryOrders {
+			oldest := h.orderIDs[0]
+			h.orderIDs = h.orderIDs[1:]
+			delete(h.orders, oldest)
+			delete(h.entries, oldest)
+		}
+		h.orderIDs = append(h.orderIDs, order.ID)
+		h.orders[order.ID] = order
+	}
+	h.entries[order.ID] = append(h.entries[order.ID], entry)
+}
+
+// Return the order and a copy of its history, or found false if the order is not known.
+func (h *history) get(orderID uuid.UUID) (order common.Order, entries []HistoryEntry, found bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	order, found = h.orders[orderID]
+	entries = append(entries, h.entries[orderID]...)
+	return
+}
@@ -0,0 +1,177 @@
+package httpapi
+
+// The httpapi package exposes the simulation over HTTP, so that other systems can feed it orders, query its state and
+// control it, and serves a browser dashboard.  The control API is also served on a local Unix socket.
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"stream-first/common"
+	"stream-first/shelf"
+	"stream-first/ui/userrequests"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	serviceName = "HTTPAPI"
+	// How long an order request waits to learn where the order landed before answering without the shelf.  This
+	// includes the time to cook the order.
+	outcomeTimeout = 15 * time.Second
+	// How long requests in flight get to complete once the server is stopped.
+	shutdownTimeout = 2 * time.Second
+)
+
+// Server handles the HTTP requests.
+type Server struct {
+	ps      common.PubsubInterface
+	clock   common.Clock
+	manager *shelf.Manager
+	layout  common.ShelfLayout
+	mux     *http.ServeMux
+	history *history
+	// Streams events to clients.
+	broadcaster *broadcaster
+
+	mu sync.Mutex
+	// Channels of the requests waiting for the outcome of their orders, by order ID.
+	waiting map[uuid.UUID]chan interface{}
+}
+
+// NewServer returns a server that publishes to ps, reports the shelves of manager and passes control requests to
+// controller.  It learns where orders landed, keeps the history of recent orders, and streams events to clients, from
+// the events received on eventCh until ctx is done.
+func NewServer(ctx context.Context, ps common.PubsubInterface, clock common.Clock, manager *shelf.Manager,
+	controller *userrequests.Controller, eventCh chan interface{}) *Server {
+	s := &Server{
+		ps:          ps,
+		clock:       clock,
+		manager:     manager,
+		layout:      manager.Layout(),
+		mux:         http.NewServeMux(),
+		history:     newHistory(),
+		broadcaster: newBroadcaster(),
+		waiting:     map[uuid.UUID]chan interface{}{},
+	}
+	s.mux.HandleFunc("/orders", s.handleOrders)
+	s.mux.HandleFunc("/orders/", s.handleOrder)
+	s.mux.HandleFunc("/shelves", s.handleShelves)
+	s.mux.HandleFunc("/events", s.handleSSE)
+	s.mux.HandleFunc("/events/ws", s.handleWebSocket)
+	control := NewControlHandler(controller, s.layout)
+	s.mux.Handle("/control", control)
+	s.mux.Handle("/control/", control)
+	s.mux.Handle("/", dashboardHandler())
+	go s.dispatch(ctx, eventCh)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Run serves the API on addr until ctx is done or the server fails.
+func Run(ctx context.Context, ps common.PubsubInterface, clock common.Clock, manager *shelf.Manager,
+	controller *userrequests.Controller, addr string) {
+	// A single subscription keeps the events in publishing order.
+	eventCh := ps.Sub(StreamTopics...)
+	s := NewServer(ctx, ps, clock, manager, controller, eventCh)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		common.Diag(ps, serviceName, common.Error, "", err)
+		return
+	}
+	common.Diag(ps, serviceName, common.Info, "Service started on "+addr+".", nil)
+	if err := serve(ctx, s, listener); err != nil {
+		common.Diag(ps, serviceName, common.Error, "", err)
+	}
+}
+
+// Serve handler on listener until ctx is done, then give the requests in flight shutdownTimeout to complete.  Event
+// streams end with ctx.
+func serve(ctx context.Context, handler http.Handler, listener net.Listener) error {
+	server := &http.Server{Handler: handler, BaseContext: func(net.Listener) context.Context { return ctx }}
+	errCh := make(chan error, 1)
+	go func() { errCh <- server.Serve(listener) }()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return server.Close()
+	}
+	return nil
+}
+
+// Stream and record the events, and pass shelved and waste events to the requests waiting for them, until ctx is
+// done.
+func (s *Server) dispatch(ctx context.Context, ch chan interface{}) {
+	for {
+		var msg interface{}
+		select {
+		case msg = <-ch:
+		case <-ctx.Done():
+			return
+		}
+		s.broadcaster.broadcast(msg)
+		s.history.record(msg)
+		var orderID uuid.UUID
+		switch e := msg.(type) {
+		case *common.ShelvedEvent:
+			orderID = e.Order.ID
+		case *common.WasteEvent:
+			orderID = e.Order.ID
+		case *common.NewOrderEvent, *common.OrderCookedEvent, *common.ReshelvedEvent, *common.PickupEvent, *common.ExpiredEvent,
+			*common.ValueEvent, *common.DiagEvent, *common.UserRequestEvent, *common.CourierDispatchedEvent,
+			*common.CourierArrivedEvent:
+			continue
+		default:
+			common.Diag(s.ps, serviceName, common.Error, common.CoerceErrorMessage(msg, e), nil)
+			continue
+		}
+		s.mu.Lock()
+		if waitCh, ok := s.waiting[orderID]; ok {
+			// Buffered, never blocks.
+			waitCh <- msg
+			delete(s.waiting, orderID)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Register interest in the outcome of an order.  Must be called before the order is published.
+func (s *Server) await(orderID uuid.UUID) chan interface{} {
+	waitCh := make(chan interface{}, 1)
+	s.mu.Lock()
+	s.waiting[orderID] = waitCh
+	s.mu.Unlock()
+	return waitCh
+}
+
+func (s *Server) forget(orderID uuid.UUID) {
+	s.mu.Lock()
+	delete(s.waiting, orderID)
+	s.mu.Unlock()
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
@@ -0,0 +1,155 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"stream-first/common"
+	"stream-first/shelflife"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Largest accepted request body.
+const maxOrdersBodyBytes = 1 << 20
+
+// OrderResult tells where a posted order landed.  Shelf is set if the order was shelved, Waste holds the waste reason
+// if it was thrown away, and neither is set if the outcome was not known in time.
+type OrderResult struct {
+	ID    uuid.UUID `json:"id"`
+	Shelf string    `json:"shelf,omitempty"`
+	Waste string    `json:"waste,omitempty"`
+}
+
+func (s *Server) handleOrders(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listOrders(w, r)
+	case http.MethodPost:
+		s.postOrders(w, r)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// POST /orders accepts a single order object, or an array of orders, in the format of the sample orders file.  Each
+// order is assigned a new ID and published as a new order.  The response holds an OrderResult for the single order, or
+// an array of them in the request order.  A batch is rejected as a whole if any of its orders is invalid.
+func (s *Server) postOrders(w http.ResponseWriter, r *http.Request) {
+	if !allowJSON(w, r) {
+		return
+	}
+	raw, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxOrdersBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	raw = bytes.TrimSpace(raw)
+	batch := len(raw) > 0 && raw[0] == '['
+
+	var orders []common.Order
+	if batch {
+		err = json.Unmarshal(raw, &orders)
+	} else {
+		var order common.Order
+		err = json.Unmarshal(raw, &order)
+		orders = append(orders, order)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return
+	}
+	if batch && len(orders) == 0 {
+		writeError(w, http.StatusBadRequest, "empty batch")
+		return
+	}
+	for i, order := range orders {
+		if err := validateOrder(s.layout, order); err != nil {
+			if batch {
+				err = errors.Wrapf(err, "order %v", i)
+			}
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	results, complete := s.publishOrders(orders)
+	status := http.StatusCreated
+	if !complete {
+		status = http.StatusAccepted
+	}
+	if batch {
+		writeJSON(w, status, results)
+	} else {
+		writeJSON(w, status, results[0])
+	}
+}
+
+func validateOrder(layout common.ShelfLayout, order common.Order) error {
+	switch {
+	case !layout.HasShelf(order.Temp) || order.Temp == common.OverflowShelfName:
+		return errors.Errorf("temp must be one of %v, got %q", layout.Temps(), order.Temp)
+	case order.ShelfLife <= 0:
+		return errors.Errorf("shelfLife must be positive, got %v", order.ShelfLife)
+	case order.DecayRate < 0:
+		return errors.Errorf("decayRate must not be negative, got %v", order.DecayRate)
+	case order.PrepSeconds < 0:
+		return errors.Errorf("prepSeconds must not be negative, got %v", order.PrepSeconds)
+	}
+	_, err := shelflife.NewDecayModel(order.Decay)
+	return errors.Wrap(err, "decay")
+}
+
+// Publish the orders, and wait for their outcomes.  complete is false if some outcomes are unknown.
+func (s *Server) publishOrders(orders []common.Order) (results []OrderResult, complete bool) {
+	waitChs := make([]chan interface{}, len(orders))
+	for i := range orders {
+		orders[i].ID = uuid.New()
+		waitChs[i] = s.await(orders[i].ID)
+		s.ps.Pub(&common.NewOrderEvent{Dt: s.clock.Now(), Order: orders[i]}, common.NewOrderTopic)
+	}
+
+	complete = true
+	timer := time.NewTimer(outcomeTimeout)
+	defer timer.Stop()
+	timedOut := false
+	for i, order := range orders {
+		var msg interface{}
+		if !timedOut {
+			select {
+			case msg = <-waitChs[i]:
+			case <-timer.C:
+				timedOut = true
+			}
+		}
+		if timedOut {
+			// Collect the outcomes that are in already.
+			select {
+			case msg = <-waitChs[i]:
+			default:
+			}
+		}
+
+		result := OrderResult{ID: order.ID}
+		switch e := msg.(type) {
+		case *common.ShelvedEvent:
+			result.Shelf = e.Shelf
+		case *common.WasteEvent:
+			result.Waste = e.Reason
+		default:
+			s.forget(order.ID)
+			complete = false
+		}
+		results = append(results, result)
+	}
+	if !complete {
+		common.Diag(s.ps, serviceName, common.Warning, fmt.Sprintf("Outcome of posted orders unknown after %v.",
+			outcomeTimeout), nil)
+	}
+	return
+}
@@ -0,0 +1,105 @@
+package httpapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"stream-first/common"
+	"stream-first/httpapi"
+	"stream-first/shelf"
+	"stream-first/ui/userrequests"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cskr/pubsub"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Start a server with a stand-in shelf service that shelves hot orders and wastes all others.
+func newServer() (s *httpapi.Server, newOrders chan common.Order) {
+	ps := pubsub.New(100)
+	newOrderCh := ps.Sub(common.NewOrderTopic)
+	manager := shelf.NewManager(ps, common.DefaultShelfLayout, shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
+	clock := common.NewSimClock(time.Now())
+	controller := userrequests.NewController(ps, clock, 3.25)
+	s = httpapi.NewServer(context.Background(), ps, clock, manager, controller, ps.Sub(common.ShelvedTopic, common.WasteTopic))
+	newOrders = make(chan common.Order, 100)
+	go func() {
+		for msg := range newOrderCh {
+			e := msg.(*common.NewOrderEvent)
+			newOrders <- e.Order
+			if e.Order.Temp == "hot" {
+				ps.Pub(&common.ShelvedEvent{Dt: e.Dt, Order: e.Order, Shelf: "hot"}, common.ShelvedTopic)
+			} else {
+				ps.Pub(&common.WasteEvent{Dt: e.Dt, Order: e.Order, Reason: common.WasteShelvesFull}, common.WasteTopic)
+			}
+		}
+	}()
+	return
+}
+
+func post(s *httpapi.Server, path string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestServer_postOrders(t *testing.T) {
+	t.Run("A single order is published with a new ID, and the response tells its shelf", func(t *testing.T) {
+		s, newOrders := newServer()
+		w := post(s, "/orders", `{"name": "Pizza", "temp": "hot", "shelfLife": 300, "decayRate": 0.45}`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var result httpapi.OrderResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.NotEqual(t, uuid.Nil, result.ID)
+		assert.Equal(t, "hot", result.Shelf)
+		assert.Empty(t, result.Waste)
+		assert.Equal(t, common.Order{ID: result.ID, Name: "Pizza", Temp: "hot", ShelfLife: 300, DecayRate: 0.45},
+			<-newOrders)
+	})
+	t.Run("A batch gets one result per order, in order", func(t *testing.T) {
+		s, newOrders := newServer()
+		w := post(s, "/orders", `[
+			{"name": "Pizza", "temp": "hot", "shelfLife": 300, "decayRate": 0.45},
+			{"name": "Salad", "temp": "cold", "shelfLife": 200, "decayRate": 0.2}]`)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var results []httpapi.OrderResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+		require.Len(t, results, 2)
+		assert.Equal(t, "hot", results[0].Shelf)
+		assert.Equal(t, common.WasteShelvesFull, results[1].Waste)
+		assert.Equal(t, "Pizza", (<-newOrders).Name)
+		assert.Equal(t, "Salad", (<-newOrders).Name)
+	})
+	t.Run("Invalid orders are rejected without publishing", func(t *testing.T) {
+		s, newOrders := newServer()
+		for _, body := range []string{
+			`{"name": "Pizza", "temp": "lukewarm", "shelfLife": 300, "decayRate": 0.45}`,
+			`{"name": "Pizza", "temp": "overflow", "shelfLife": 300, "decayRate": 0.45}`,
+			`{"name": "Pizza", "temp": "hot", "shelfLife": 0, "decayRate": 0.45}`,
+			`{"name": "Pizza", "temp": "hot", "shelfLife": 300, "decayRate": -1}`,
+			`{"name": "Fries", "temp": "hot", "shelfLife": 60, "decayRate": 1, "decay": {"model": "soggy"}}`,
+			`[{"name": "Pizza", "temp": "hot", "shelfLife": 300, "decayRate": 0.45}, {"temp": "hot"}]`,
+			`[]`,
+			`{"name": `,
+		} {
+			w := post(s, "/orders", body)
+			assert.Equal(t, http.StatusBadRequest, w.Code, body)
+			assert.Contains(t, w.Body.String(), `"error"`, body)
+		}
+		time.Sleep(common.Seconds(common.SchedulerDelay))
+		assert.Empty(t, newOrders)
+	})
+	t.Run("Only GET and POST are allowed", func(t *testing.T) {
+		s, _ := newServer()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/orders", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
@@ -0,0 +1,89 @@
+package httpapi
+
+import (
+	"net/http"
+	"stream-first/common"
+	"stream-first/shelflife"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// OrderStatus describes an order.  Shelf, value and normalized value are only set while the order is on a shelf.
+type OrderStatus struct {
+	ID        uuid.UUID      `json:"id"`
+	Name      string         `json:"name"`
+	Temp      string         `json:"temp"`
+	ShelfLife float32        `json:"shelfLife"`
+	DecayRate float32        `json:"decayRate"`
+	Shelf     string         `json:"shelf,omitempty"`
+	Value     *float32       `json:"value,omitempty"`
+	NormValue *float32       `json:"normValue,omitempty"`
+	History   []HistoryEntry `json:"history,omitempty"`
+}
+
+func newOrderStatus(order common.Order) OrderStatus {
+	return OrderStatus{ID: order.ID, Name: order.Name, Temp: order.Temp, ShelfLife: order.ShelfLife,
+		DecayRate: order.DecayRate}
+}
+
+func (o *OrderStatus) setValue(v shelflife.OrderValue) {
+	o.Shelf, o.Value, o.NormValue = v.Shelf, &v.Value, &v.NormValue
+}
+
+// GET /shelves lists the shelves with their capacity and occupancy.
+func (s *Server) handleShelves(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.manager.Shelves())
+}
+
+// GET /orders lists the orders on the shelves, with their current shelf and value.
+func (s *Server) listOrders(w http.ResponseWriter, r *http.Request) {
+	statuses := []OrderStatus{}
+	for _, v := range shelflife.Values(s.clock.Now()) {
+		status := newOrderStatus(v.Order)
+		status.setValue(v)
+		statuses = append(statuses, status)
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// GET /orders/{id} returns a recent order with the history of its events.  Orders that are still on the shelves
+// include their current shelf and value.
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	orderID, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, "/orders/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid order ID: "+err.Error())
+		return
+	}
+
+	order, entries, found := s.history.get(orderID)
+	v, onShelf := shelflife.ValueOf(orderID, s.clock.Now())
+	if !found && !onShelf {
+		writeError(w, http.StatusNotFound, "order not found: "+orderID.String())
+		return
+	}
+	if !found {
+		order = v.Order
+	}
+	status := newOrderStatus(order)
+	if onShelf {
+		status.setValue(v)
+	}
+	status.History = entries
+	writeJSON(w, http.StatusOK, status)
+}
+
+func allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package httpapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"stream-first/common"
+	"stream-first/httpapi"
+	"stream-first/shelf"
+	"stream-first/shelflife"
+	"stream-first/ui/userrequests"
+	"testing"
+	"time"
+
+	"github.com/cskr/pubsub"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(s *httpapi.Server, path string, v interface{}) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code == http.StatusOK {
+		_ = json.Unmarshal(w.Body.Bytes(), v)
+	}
+	return w
+}
+
+func TestServer_query(t *testing.T) {
+	ps := pubsub.New(100)
+	clock := common.NewSimClock(time.Now())
+	layout := common.NewUniformShelfLayout([]string{"hot", "cold"}, 1, 3)
+	manager := shelf.NewManager(ps, layout, shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
+	eventCh := make(chan interface{})
+	s := httpapi.NewServer(context.Background(), ps, clock, manager, userrequests.NewController(ps, clock, 1), eventCh)
+
+	shelved := common.Order{ID: uuid.New(), Name: "Pizza", Temp: "hot", ShelfLife: 100, DecayRate: 0.5}
+	pickedUp := common.Order{ID: uuid.New(), Name: "Soup", Temp: "hot", ShelfLife: 100, DecayRate: 0.5}
+	now := clock.Now()
+	_, _ = manager.Store(shelved, "hot", now)
+	_, _ = manager.Store(pickedUp, "hot", now)
+	// The order values come from the shelf life service.
+	shelflife.ResetStates()
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	shelvedCh := make(chan interface{})
+	go shelflife.Run0(ctx, ps, clock, layout, time.Hour, shelvedCh, make(chan interface{}), make(chan interface{}),
+		make(chan interface{}))
+	shelvedCh <- &common.ShelvedEvent{Dt: now, Order: shelved, Shelf: "hot"}
+	for _, e := range []interface{}{
+		&common.NewOrderEvent{Dt: now, Order: shelved},
+		&common.ShelvedEvent{Dt: now, Order: shelved, Shelf: "hot"},
+		&common.NewOrderEvent{Dt: now, Order: pickedUp},
+		&common.ShelvedEvent{Dt: now, Order: pickedUp, Shelf: common.OverflowShelfName},
+		&common.PickupEvent{Dt: now.Add(time.Second), Order: pickedUp},
+	} {
+		eventCh <- e
+	}
+	clock.Advance(10 * time.Second)
+	time.Sleep(common.Seconds(common.SchedulerDelay))
+
+	t.Run("Shelves are listed with capacity and occupancy", func(t *testing.T) {
+		var shelves []shelf.ShelfStatus
+		w := get(s, "/shelves", &shelves)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []shelf.ShelfStatus{
+			{Name: "hot", Capacity: 1, Occupancy: 1},
+			{Name: "cold", Capacity: 1, Occupancy: 0},
+			{Name: common.OverflowShelfName, Capacity: 3, Occupancy: 1},
+		}, shelves)
+	})
+	t.Run("Orders on the shelves are listed with their value", func(t *testing.T) {
+		var orders []httpapi.OrderStatus
+		w := get(s, "/orders", &orders)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Len(t, orders, 1)
+		assert.Equal(t, shelved.ID, orders[0].ID)
+		assert.Equal(t, "hot", orders[0].Shelf)
+		require.NotNil(t, orders[0].Value)
+		assert.InDelta(t, 100-10-5, *orders[0].Value, 1e-3)
+		assert.InDelta(t, 0.85, *orders[0].NormValue, 1e-3)
+		assert.Empty(t, orders[0].History)
+	})
+	t.Run("An order is returned with its history", func(t *testing.T) {
+		var order httpapi.OrderStatus
+		w := get(s, "/orders/"+pickedUp.ID.String(), &order)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "Soup", order.Name)
+		assert.Empty(t, order.Shelf)
+		assert.Nil(t, order.Value)
+		require.Len(t, order.History, 3)
+		assert.Equal(t, common.NewOrderTopic, order.History[0].Event)
+		assert.True(t, now.Equal(order.History[1].Dt))
+		assert.Equal(t, common.ShelvedTopic, order.History[1].Event)
+		assert.Equal(t, common.OverflowShelfName, order.History[1].Shelf)
+		assert.Equal(t, common.PickupTopic, order.History[2].Event)
+	})
+	t.Run("Unknown and malformed order IDs are reported", func(t *testing.T) {
+		var order httpapi.OrderStatus
+		assert.Equal(t, http.StatusNotFound, get(s, "/orders/"+uuid.New().String(), &order).Code)
+		assert.Equal(t, http.StatusBadRequest, get(s, "/orders/not-an-id", &order).Code)
+	})
+}
@@ -0,0 +1,216 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"stream-first/common"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// Events queued for a streaming client beyond this many are dropped, so that slow clients don't hold up the others.
+const clientBufferSize = 256
+
+// StreamTopics lists the topics that can be streamed.
+var StreamTopics = []string{common.NewOrderTopic, common.OrderCookedTopic, common.ShelvedTopic, common.ReshelvedTopic,
+	common.PickupTopic, common.ExpiredTopic, common.WasteTopic, common.ValueTopic, common.DiagTopic,
+	common.UserRequestTopic, common.CourierDispatchedTopic, common.CourierArrivedTopic}
+
+// An encoded envelope.
+type streamed struct {
+	topic string
+	data  []byte
+}
+
+// A streaming client.
+type client struct {
+	topics map[string]bool
+	ch     chan streamed
+
+	mu sync.Mutex
+	// Number of events dropped since last reported to the client.
+	dropped int
+}
+
+// Return the number of dropped events, and reset it.
+func (c *client) takeDropped() (dropped int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dropped, c.dropped = c.dropped, 0
+	return
+}
+
+// The clients of the stream, and their filters.
+type broadcaster struct {
+	mu      sync.Mutex
+	clients map[*client]bool
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{clients: map[*client]bool{}}
+}
+
+func (b *broadcaster) add(topics map[string]bool) *client {
+	c := &client{topics: topics, ch: make(chan streamed, clientBufferSize)}
+	b.mu.Lock()
+	b.clients[c] = true
+	b.mu.Unlock()
+	return c
+}
+
+func (b *broadcaster) remove(c *client) {
+	b.mu.Lock()
+	delete(b.clients, c)
+	b.mu.Unlock()
+}
+
+// Pass the event to the clients that asked for its topic.  Never blocks.
+func (b *broadcaster) broadcast(msg interface{}) {
+	envelope, err := common.NewEnvelope(msg)
+	if err != nil {
+		return
+	}
+	topic := envelope.Topic()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var data []byte
+	for c := range b.clients {
+		if !c.topics[topic] {
+			continue
+		}
+		if data == nil {
+			if data, err = json.Marshal(envelope); err != nil {
+				return
+			}
+		}
+		select {
+		case c.ch <- streamed{topic, data}:
+		default:
+			c.mu.Lock()
+			c.dropped++
+			c.mu.Unlock()
+		}
+	}
+}
+
+// Parse the topics query parameter, a comma separated list of topics.  All topics are streamed if it is empty.
+func parseTopics(r *http.Request) (topics map[string]bool, err error) {
+	topics = map[string]bool{}
+	param := r.URL.Query().Get("topics")
+	if param == "" {
+		for _, topic := range StreamTopics {
+			topics[topic] = true
+		}
+		return
+	}
+	for _, topic := range strings.Split(param, ",") {
+		if !isStreamTopic(topic) {
+			return nil, errors.Errorf("unknown topic %q, expected some of %v", topic, StreamTopics)
+		}
+		topics[topic] = true
+	}
+	return
+}
+
+func isStreamTopic(topic string) bool {
+	for _, t := range StreamTopics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// GET /events streams events as server-sent events, named by topic.  The topics query parameter selects the topics.
+// When events had to be dropped because the client fell behind, a "dropped" event tells how many.
+func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	topics, err := parseTopics(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	c := s.broadcaster.add(topics)
+	defer s.broadcaster.remove(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case e := <-c.ch:
+			if dropped := c.takeDropped(); dropped > 0 {
+				_, _ = fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%v}\n\n", dropped)
+			}
+			if _, err := fmt.Fprintf(w, "event: %v\ndata: %s\n\n", e.topic, e.data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// The default origin check only lets pages served by the same host open a WebSocket, so other sites a browser visits
+// can't read the events.
+var upgrader = websocket.Upgrader{}
+
+// GET /events/ws streams events over a WebSocket, one Envelope per text message.  The topics query parameter selects
+// the topics.  When events had to be dropped because the client fell behind, a {"dropped": n} message tells how many.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	topics, err := parseTopics(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader replied already.
+		return
+	}
+	defer conn.Close()
+	c := s.broadcaster.add(topics)
+	defer s.broadcaster.remove(c)
+
+	// Reading is needed to notice the client closing the connection.  Incoming messages are ignored.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case e := <-c.ch:
+			if dropped := c.takeDropped(); dropped > 0 {
+				if err := conn.WriteJSON(map[string]int{"dropped": dropped}); err != nil {
+					return
+				}
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, e.data); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
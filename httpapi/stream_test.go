@@ -0,0 +1,143 @@
+package httpapi_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"stream-first/common"
+	"stream-first/httpapi"
+	"stream-first/shelf"
+	"stream-first/ui/userrequests"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cskr/pubsub"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStreamServer() (ts *httptest.Server, eventCh chan interface{}) {
+	ps := pubsub.New(100)
+	manager := shelf.NewManager(ps, common.DefaultShelfLayout, shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
+	eventCh = make(chan interface{})
+	clock := common.NewSimClock(time.Now())
+	ts = httptest.NewServer(httpapi.NewServer(context.Background(), ps, clock, manager, userrequests.NewController(ps, clock, 1), eventCh))
+	return
+}
+
+// Read the next server-sent event.
+func readSSE(t *testing.T, r *bufio.Reader) (event string, data string) {
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && event != "":
+			return
+		}
+	}
+}
+
+func TestServer_sse(t *testing.T) {
+	ts, eventCh := newStreamServer()
+	defer ts.Close()
+
+	t.Run("Only the requested topics are streamed", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/events?topics=pickup,diag")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		r := bufio.NewReader(resp.Body)
+
+		order := common.Order{ID: uuid.New(), Name: "Pizza", Temp: "hot"}
+		eventCh <- &common.ShelvedEvent{Order: order, Shelf: "hot"}
+		eventCh <- &common.PickupEvent{Order: order}
+		eventCh <- &common.DiagEvent{ServiceName: "Test", Severity: common.Error, Error: assert.AnError}
+
+		event, data := readSSE(t, r)
+		assert.Equal(t, common.PickupTopic, event)
+		var envelope common.Envelope
+		require.NoError(t, json.Unmarshal([]byte(data), &envelope))
+		assert.Equal(t, common.PickupTopic, envelope.Topic())
+		assert.Equal(t, order.ID, envelope.CorrelationID)
+		assert.Equal(t, order, envelope.Payload.(*common.PickupEvent).Order)
+
+		event, data = readSSE(t, r)
+		assert.Equal(t, common.DiagTopic, event)
+		assert.Contains(t, data, assert.AnError.Error())
+	})
+	t.Run("Unknown topics are rejected", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/events?topics=pickup,gossip")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestServer_webSocket(t *testing.T) {
+	ts, eventCh := newStreamServer()
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/events/ws?topics=value"
+
+	t.Run("Events are streamed as json envelopes", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		time.Sleep(common.Seconds(common.SchedulerDelay))
+
+		order := common.Order{ID: uuid.New(), Name: "Pizza", Temp: "hot"}
+		eventCh <- &common.PickupEvent{Order: order}
+		eventCh <- &common.ValueEvent{Order: order, Shelf: "hot", Value: 10, NormValue: 0.5}
+		var envelope common.Envelope
+		require.NoError(t, conn.ReadJSON(&envelope))
+		assert.Equal(t, common.ValueTopic, envelope.Topic())
+		assert.Equal(t, float32(0.5), envelope.Payload.(*common.ValueEvent).NormValue)
+	})
+	t.Run("Pages served by other hosts are refused", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://example.com"}})
+		require.Error(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {ts.URL}})
+		require.NoError(t, err)
+		conn.Close()
+	})
+	t.Run("A slow client doesn't block the stream, and is told how many events it missed", func(t *testing.T) {
+		slow, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		defer slow.Close()
+		time.Sleep(common.Seconds(common.SchedulerDelay))
+
+		// Far more events than fit the client buffer and the connection buffers.
+		done := make(chan bool)
+		go func() {
+			for i := 0; i < 20000; i++ {
+				eventCh <- &common.ValueEvent{Order: common.Order{ID: uuid.New(), Name: strings.Repeat("x", 100)}}
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "publishing blocked")
+		}
+
+		for {
+			var msg map[string]interface{}
+			require.NoError(t, slow.ReadJSON(&msg))
+			if dropped, ok := msg["dropped"]; ok {
+				assert.Greater(t, dropped.(float64), 0.0)
+				break
+			}
+		}
+	})
+}
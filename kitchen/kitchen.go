@@ -0,0 +1,115 @@
+package kitchen
+
+// The kitchen service cooks new orders before they are shelved.  Each temp has its own cooking stations.  A new order
+// waits for a station of its temp to be free, cooks for its prep time, and is then published as cooked, for the shelf
+// service to shelve.  Orders lose value from the time they are cooked.
+
+import (
+	"context"
+	"fmt"
+	"stream-first/common"
+	"sync"
+	"time"
+
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+const (
+	serviceName = "Kitchen"
+)
+
+// Run cooks new orders on the given number of stations by temp, until ctx is done.  Orders without a prep time of
+// their own cook for between minSeconds and maxSeconds.  Prep times are derived from seed.
+func Run(ctx context.Context, ps common.PubsubInterface, clock common.Clock, seed uint64, stations map[string]int,
+	minSeconds float64, maxSeconds float64) {
+	ch := ps.Sub(common.NewOrderTopic)
+
+	// Allow time for other components to subscribe before starting to publish.
+	time.Sleep(common.Seconds(common.SchedulerDelay))
+	common.Diag(ps, serviceName, common.Info, "Service started.", nil)
+
+	prep := distuv.Uniform{Min: minSeconds, Max: maxSeconds, Src: common.NewSource(seed, serviceName)}
+
+	Run0(ctx, prep, ps, clock, stations, ch)
+}
+
+// A finished order.
+type cooked struct {
+	order common.Order
+	at    time.Time
+}
+
+// Run0 is a testable version of the service.  It allows injecting mocks for pub/sub, prep time draws and the clock.
+// It returns once ctx is done and the orders cooking are dropped.
+func Run0(ctx context.Context, prep common.RandInterface, ps common.PubsubInterface, clock common.Clock,
+	stations map[string]int, ch chan interface{}) {
+	free := map[string]int{}
+	for temp, count := range stations {
+		free[temp] = count
+	}
+	// Orders waiting for a station, by temp, in arrival order.
+	queues := map[string][]common.Order{}
+	cookedCh := make(chan cooked)
+	var cooking sync.WaitGroup
+
+	startCooking := func(order common.Order) {
+		free[order.Temp]--
+		d := common.Seconds(float64(order.PrepSeconds))
+		if order.PrepSeconds <= 0 {
+			d = common.Seconds(prep.Rand())
+		}
+		timer := clock.NewTimer(d)
+		cooking.Add(1)
+		go func() {
+			defer cooking.Done()
+			select {
+			case at := <-timer.C():
+				select {
+				case cookedCh <- cooked{order: order, at: at}:
+				case <-ctx.Done():
+				}
+			case <-ctx.Done():
+				timer.Stop()
+			}
+		}()
+	}
+
+	for {
+		select {
+		case msg := <-ch:
+			e, ok := msg.(*common.NewOrderEvent)
+			if !ok {
+				common.Diag(ps, serviceName, common.Error, common.CoerceErrorMessage(msg, e), nil)
+				continue
+			}
+			temp := e.Order.Temp
+			if _, ok := stations[temp]; !ok {
+				// Leave it to the shelves to reject the order.
+				common.Diag(ps, serviceName, common.Error,
+					fmt.Sprintf("No cooking station for %v orders, not cooked: %+v", temp, e.Order), nil)
+				ps.Pub(&common.OrderCookedEvent{Dt: e.Dt, Order: e.Order}, common.OrderCookedTopic)
+				continue
+			}
+			if free[temp] == 0 {
+				if len(queues[temp]) == 0 {
+					common.Diag(ps, serviceName, common.Warning,
+						fmt.Sprintf("All %v stations are busy, orders wait for a station.", temp), nil)
+				}
+				queues[temp] = append(queues[temp], e.Order)
+				continue
+			}
+			startCooking(e.Order)
+		case c := <-cookedCh:
+			ps.Pub(&common.OrderCookedEvent{Dt: c.at, Order: c.order}, common.OrderCookedTopic)
+			temp := c.order.Temp
+			free[temp]++
+			if queue := queues[temp]; len(queue) > 0 {
+				queues[temp] = queue[1:]
+				startCooking(queue[0])
+			}
+		case <-ctx.Done():
+			cooking.Wait()
+			return
+		}
+	}
+}
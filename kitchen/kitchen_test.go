@@ -0,0 +1,108 @@
+package kitchen_test
+
+import (
+	"context"
+	"stream-first/common"
+	"stream-first/kitchen"
+	"stream-first/mocks"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var prepTime = common.Seconds(1.5)
+
+func newOrder(temp string) common.Order {
+	return common.Order{ID: uuid.New(), Name: "an order", Temp: temp, ShelfLife: 100, DecayRate: 1}
+}
+
+func TestRun0(t *testing.T) {
+	t.Run("An order is published as cooked once its prep time is over", func(t *testing.T) {
+		clock, ch, pubCh, stop := start()
+		defer stop()
+
+		order := newOrder("hot")
+		ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: order}
+		clock.BlockUntil(1)
+		clock.Advance(prepTime - time.Millisecond)
+		mocks.None(t, pubCh)
+		clock.Advance(time.Millisecond)
+		assert.Equal(t, &common.OrderCookedEvent{Dt: clock.Now(), Order: order}, mocks.Next(t, pubCh))
+	})
+	t.Run("Orders with a prep time of their own cook for that long", func(t *testing.T) {
+		clock, ch, pubCh, stop := start()
+		defer stop()
+
+		order := newOrder("hot")
+		order.PrepSeconds = 0.5
+		ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: order}
+		clock.BlockUntil(1)
+		clock.Advance(common.Seconds(0.5))
+		assert.Equal(t, &common.OrderCookedEvent{Dt: clock.Now(), Order: order}, mocks.Next(t, pubCh))
+	})
+	t.Run("Orders wait for a station of their temp to be free", func(t *testing.T) {
+		clock, ch, pubCh, stop := start()
+		defer stop()
+
+		hot1, hot2, cold := newOrder("hot"), newOrder("hot"), newOrder("cold")
+		for _, order := range []common.Order{hot1, hot2, cold} {
+			ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: order}
+		}
+		assert.IsType(t, &common.DiagEvent{}, mocks.Next(t, pubCh))
+		clock.BlockUntil(2)
+		clock.Advance(prepTime)
+		cooked := []interface{}{mocks.Next(t, pubCh), mocks.Next(t, pubCh)}
+		assert.ElementsMatch(t, []interface{}{
+			&common.OrderCookedEvent{Dt: clock.Now(), Order: hot1},
+			&common.OrderCookedEvent{Dt: clock.Now(), Order: cold},
+		}, cooked)
+		clock.BlockUntil(1)
+		clock.Advance(prepTime)
+		assert.Equal(t, &common.OrderCookedEvent{Dt: clock.Now(), Order: hot2}, mocks.Next(t, pubCh))
+	})
+	t.Run("Orders of temps without stations are passed on uncooked", func(t *testing.T) {
+		clock, ch, pubCh, stop := start()
+		defer stop()
+
+		order := newOrder("lukewarm")
+		ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: order}
+		assert.IsType(t, &common.DiagEvent{}, mocks.Next(t, pubCh))
+		assert.Equal(t, &common.OrderCookedEvent{Dt: clock.Now(), Order: order}, mocks.Next(t, pubCh))
+	})
+	t.Run("Stopping the service drops the orders cooking", func(t *testing.T) {
+		ps, clock, ch := &mocks.MockPubsub{}, common.NewSimClock(time.Now()), make(chan interface{})
+		pubCh := ps.Record()
+		ctx, stop := context.WithCancel(context.Background())
+		done := make(chan bool)
+		go func() {
+			kitchen.Run0(ctx, mocks.MockRand{MockResult: prepTime.Seconds()}, ps, clock, map[string]int{"hot": 1}, ch)
+			done <- true
+		}()
+
+		ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: newOrder("hot")}
+		clock.BlockUntil(1)
+		stop()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("the service did not stop")
+		}
+		clock.Advance(prepTime)
+		mocks.None(t, pubCh)
+	})
+}
+
+// Start the service with one hot and one cold station.  Events published by the service are passed on pubCh.
+func start() (clock *common.SimClock, ch chan interface{}, pubCh chan interface{},
+	stop context.CancelFunc) {
+	ps := &mocks.MockPubsub{}
+	pubCh = ps.Record()
+	clock, ch = common.NewSimClock(time.Now()), make(chan interface{})
+	var ctx context.Context
+	ctx, stop = context.WithCancel(context.Background())
+	stations := map[string]int{"hot": 1, "cold": 1}
+	go kitchen.Run0(ctx, mocks.MockRand{MockResult: prepTime.Seconds()}, ps, clock, stations, ch)
+	return
+}
@@ -1,42 +1,399 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"github.com/cskr/pubsub"
+	"io"
 	"os"
+	"os/signal"
 	"stream-first/common"
+	"stream-first/config"
+	"stream-first/eventlog"
+	"stream-first/headless"
+	"stream-first/httpapi"
+	"stream-first/kitchen"
+	"stream-first/natsbus"
 	input "stream-first/ordersender"
 	"stream-first/pickup"
+	"stream-first/report"
 	"stream-first/shelf"
 	"stream-first/shelflife"
+	"stream-first/supervisor"
 	"stream-first/ui"
 	"stream-first/ui/userrequests"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
-const serviceName = "Main"
+const (
+	serviceName = "Main"
+	// How long the services get to stop once the run ends.
+	shutdownTimeout = 3 * time.Second
+)
+
+// Subcommands.  Without one, all services run in a single process.
+const (
+	allServices        = ""
+	orderSenderService = "ordersender"
+	kitchenService     = "kitchen"
+	shelfService       = "shelf"
+	shelfLifeService   = "shelflife"
+	pickupService      = "pickup"
+	// The UI service also keeps the user requests, the report and the event log.
+	uiService = "ui"
+	// Runs a stand-in NATS server.
+	busCommand = "bus"
+	// Runs every service in its own process.
+	superviseCommand = "supervise"
+)
+
+const usage = `usage: %[1]v [subcommand] [flags]
+
+Without a subcommand, all services run in this process.  Subcommands run a single service, talking to the others
+over the -bus NATS server:
+
+  ordersender  sends new orders, or replays them from -replay
+  kitchen      cooks orders
+  shelf        shelves orders
+  shelflife    tracks order values and expiry
+  pickup       picks orders up
+  ui           the terminal UI, or the headless log, user requests, report and event log
+  bus          runs a stand-in NATS server listening on the -bus url
+  supervise    runs all of the above as separate processes, restarting services that fail
 
-// Launch all services and wait for the quit user request.
+Run %[1]v -h for the flags, they are the same for every subcommand.
+`
+
+// Launch the services of the subcommand, and stop them once the user asks to quit.
 func main() {
-	ps := pubsub.New(1000)
+	command, args := allServices, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	switch command {
+	case allServices, orderSenderService, kitchenService, shelfService, shelfLifeService, pickupService, uiService:
+		runServices(command, args)
+	case busCommand:
+		runBus(args)
+	case superviseCommand:
+		runSupervisor(args)
+	default:
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		os.Exit(2)
+	}
+}
+
+// Load the configuration of a subcommand, or exit.
+func loadConfig(command string, args []string) config.Config {
+	name := strings.TrimSpace(os.Args[0] + " " + command)
+	cfg, err := config.Load(name, args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err == nil && command != allServices {
+		err = validateSplit(cfg)
+	}
+	if err != nil {
+		exitOnError(err)
+	}
+	return cfg
+}
+
+// Services in separate processes can only share a network bus and real time.
+func validateSplit(cfg config.Config) error {
+	switch {
+	case cfg.Clock != config.RealClock:
+		return fmt.Errorf("services in separate processes need the %q clock", config.RealClock)
+	case cfg.HTTPAddr != "":
+		return fmt.Errorf("the HTTP API reads the shelves directly, so it runs with all services in one process only")
+	}
+	return nil
+}
+
+func exitOnError(err error) {
+	fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+	os.Exit(2)
+}
+
+// Run the given service, or all of them.
+func runServices(service string, args []string) {
+	cfg := loadConfig(service, args)
+	if service != allServices && cfg.Bus == config.LocalBus {
+		exitOnError(fmt.Errorf("the %v service needs a NATS server, see -bus", service))
+	}
+	runs := func(s string) bool { return service == allServices || service == s }
 
+	layout, err := common.LoadShelfLayout(cfg.ShelfLayoutFile)
+	if err != nil {
+		exitOnError(err)
+	}
+	// Open the event log files, and load the orders, before any service starts, so that failing to do so exits with
+	// the terminal as it was.  The replay and the orders come first, so that a missing replay or bad orders do not
+	// leave an empty recording behind.
+	var replayFile, recordFile *os.File
+	var orders []common.Order
+	if runs(orderSenderService) && cfg.ReplayFile != "" {
+		if replayFile, err = os.Open(cfg.ReplayFile); err != nil {
+			exitOnError(err)
+		}
+	} else if runs(orderSenderService) {
+		if orders, err = input.LoadOrders(cfg.OrdersFile); err != nil {
+			exitOnError(err)
+		}
+	}
+	if runs(uiService) && cfg.RecordFile != "" {
+		if recordFile, err = os.Create(cfg.RecordFile); err != nil {
+			exitOnError(err)
+		}
+	}
+
+	var clock common.Clock = common.RealClock{}
+	var simBus *common.SimBus
+	if cfg.Clock == config.SimClock {
+		simClock := common.NewSimClock(time.Now())
+		simBus = common.NewSimBus(simClock, cfg.BufferSize)
+		clock = simClock
+	}
+	common.DiagClock = clock
+
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = common.NewSeed()
+	}
+	if runs(uiService) {
+		// The screen is cleared once the UI starts, so the seed is posted as a diagnostic message as well.
+		fmt.Fprintf(os.Stderr, "Random seed: %v\n", seed)
+	}
+
+	var bus common.PubsubInterface = pubsub.New(cfg.BufferSize)
+	var natsBus *natsbus.Bus
+	if simBus != nil {
+		bus = simBus
+	} else if cfg.Bus != config.LocalBus {
+		if natsBus, err = natsbus.Dial(cfg.Bus, cfg.BufferSize); err != nil {
+			exitOnError(err)
+		}
+		bus = natsBus
+	}
+	// Events of the wrong type for their topic are caught when published, rather than by each subscriber.
+	ps := common.ValidatingPubsub{PubsubInterface: bus}
 	userCh := ps.Sub(common.UserRequestTopic)
-	go ui.Run(ps)
-	go input.Run(ps)
-	go shelf.Run(ps)
-	go shelflife.Run(ps)
-	go pickup.Run(ps)
 
-	for {
-		msg := <-userCh
-		userRequest, ok := msg.(string)
+	// The services stop on a quit user request, SIGINT or SIGTERM.
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	ctx, cancel := context.WithCancel(ctx)
+	quitCh := make(chan bool, 1)
+	go func() {
+		quitCh <- waitForQuit(ctx, ps, userCh)
+		cancel()
+	}()
+	// The report and the event log stop after the other services, so that they see their last events.
+	sinkCtx, stopSinks := context.WithCancel(context.Background())
+	var services, sinks sync.WaitGroup
+	start := func(wg *sync.WaitGroup, run func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run()
+		}()
+	}
+	if simBus != nil {
+		start(&services, func() { simBus.Drive(ctx) })
+	}
+
+	var manager *shelf.Manager
+	if runs(shelfService) {
+		eviction, err := shelf.NewEvictionPolicy(cfg.Eviction, common.NewSource(seed, "Shelf/Eviction"))
+		if err != nil {
+			exitOnError(err)
+		}
+		reshelving, err := shelf.NewReshelfStrategy(cfg.Reshelving)
+		if err != nil {
+			exitOnError(err)
+		}
+		manager = shelf.NewManager(ps, layout, eviction, reshelving)
+		// Orders are picked up halfway through the pickup range on average.
+		rebalanceHorizon := common.Seconds((cfg.PickupMinSeconds + cfg.PickupMaxSeconds) / 2)
+		start(&services, func() {
+			shelf.Run(ctx, ps, clock, manager, common.Seconds(cfg.RebalanceSeconds), rebalanceHorizon)
+		})
+	}
+	if runs(shelfLifeService) {
+		start(&services, func() { shelflife.Run(ctx, ps, clock, layout, cfg.KeepAliveSeconds) })
+	}
+	// A replay stands in for the order sender, the kitchen and the pickup service.
+	if runs(kitchenService) && cfg.ReplayFile == "" {
+		for _, temp := range layout.Temps() {
+			if cfg.Stations[temp] < 1 {
+				exitOnError(fmt.Errorf("no cooking stations for %v orders, see -stations", temp))
+			}
+		}
+		start(&services, func() {
+			kitchen.Run(ctx, ps, clock, seed, cfg.Stations, cfg.PrepMinSeconds, cfg.PrepMaxSeconds)
+		})
+	}
+	if runs(pickupService) && cfg.ReplayFile == "" {
+		strategy, err := pickup.NewPickupStrategy(cfg.PickupStrategy)
+		if err != nil {
+			exitOnError(err)
+		}
+		start(&services, func() {
+			pickup.Run(ctx, ps, clock, seed, cfg.Couriers, cfg.PickupMinSeconds, cfg.PickupMaxSeconds, strategy)
+		})
+	}
+	if runs(orderSenderService) {
+		if replayFile != nil {
+			start(&services, func() { eventlog.Replay(ctx, ps, clock, replayFile, cfg.ReplayPace) })
+		} else {
+			start(&services, func() {
+				input.Run(ctx, ps, clock, seed, orders, cfg.ArrivalRate, cfg.MaxOrders)
+			})
+		}
+	}
+
+	var controller *userrequests.Controller
+	var rep *report.Report
+	if runs(uiService) {
+		controller = userrequests.NewController(ps, clock, cfg.ArrivalRate)
+		rep = report.New()
+		start(&sinks, func() { rep.Run(sinkCtx, ps) })
+		if !cfg.Headless {
+			start(&services, func() { ui.Run(ctx, ps, clock, layout, controller) })
+		}
+		if recordFile != nil {
+			start(&sinks, func() { eventlog.Record(sinkCtx, ps, recordFile) })
+		}
+		if cfg.HTTPAddr != "" {
+			start(&services, func() { httpapi.Run(ctx, ps, clock, manager, controller, cfg.HTTPAddr) })
+		}
+		if cfg.ControlSocket != "" {
+			start(&services, func() { httpapi.RunControlSocket(ctx, ps, controller, layout, cfg.ControlSocket) })
+		}
+	}
+
+	if natsBus != nil {
+		go func() {
+			// Allow time for the services to subscribe, and for the server to take the subscriptions, before the
+			// supervisor starts the order sender.
+			time.Sleep(common.Seconds(common.SchedulerDelay))
+			err := natsBus.Flush()
+			if err == nil {
+				err = supervisor.NotifyReady()
+			}
+			if err != nil {
+				common.Diag(ps, serviceName, common.Error, "", err)
+			}
+		}()
+	}
+
+	exitCode := headless.ExitOK
+	if runs(uiService) && cfg.Headless {
+		exitCode = headless.Run(ctx, ps, clock, cfg.MaxOrders, common.Seconds(cfg.RunSeconds))
+	} else {
+		if runs(uiService) {
+			// Allow time for the UI to subscribe.
+			time.Sleep(common.Seconds(common.SchedulerDelay))
+			common.Diag(ps, serviceName, common.Info, fmt.Sprintf("Random seed: %v", seed), nil)
+		}
+		<-ctx.Done()
+	}
+
+	cancel()
+	if quitRequested := <-quitCh; runs(uiService) && !quitRequested {
+		// Services in other processes end with the run.
+		_, _ = controller.Request(userrequests.QuitRequest)
+	}
+	if !waitTimeout(&services, shutdownTimeout) {
+		fmt.Fprintf(os.Stderr, "%v: services still running after %v\n", os.Args[0], shutdownTimeout)
+	}
+	// Allow the last events to reach the report and the event log.
+	if simBus != nil {
+		simBus.Flush()
+	} else {
+		time.Sleep(common.Seconds(common.SchedulerDelay))
+	}
+	stopSinks()
+	sinks.Wait()
+	if recordFile != nil {
+		if err := recordFile.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			exitCode = headless.ExitError
+		}
+	}
+	if rep != nil {
+		if !cfg.Headless {
+			// The cursor is left on the screen drawn by the UI.
+			fmt.Println()
+		}
+		if err := writeReport(rep, cfg.ReportFile); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			exitCode = headless.ExitError
+		}
+	}
+	if closer, ok := bus.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	os.Exit(exitCode)
+}
+
+// Return true once the user asks to quit, or false if ctx is done first.
+func waitForQuit(ctx context.Context, ps common.PubsubInterface, userCh chan interface{}) (quitRequested bool) {
+	isQuit := func(msg interface{}) bool {
+		userRequest, ok := msg.(*common.UserRequestEvent)
 		if !ok {
 			common.Diag(ps, serviceName, common.Error, common.CoerceErrorMessage(msg, userRequest), nil)
+			return false
 		}
-
-		switch userRequest {
-		case userrequests.QuitRequest:
-			fmt.Printf("\r\n")
-			os.Exit(0)
+		return userRequest.Request == userrequests.QuitRequest
+	}
+	for {
+		select {
+		case msg := <-userCh:
+			if isQuit(msg) {
+				return true
+			}
+		case <-ctx.Done():
+			// The quit may be what ended the run.
+			common.Drain(userCh, func(msg interface{}) { quitRequested = quitRequested || isQuit(msg) })
+			return
 		}
 	}
 }
+
+// Wait for wg, and tell whether it was done within timeout.
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Print the run summary, and save it in json format if a report file is configured.
+func writeReport(rep *report.Report, reportFile string) error {
+	summary := rep.Summary()
+	fmt.Print(summary.String())
+	if reportFile == "" {
+		return nil
+	}
+	f, err := os.Create(reportFile)
+	if err != nil {
+		return err
+	}
+	if err := summary.WriteJSON(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Set to the arguments of a run, the test binary runs the program instead of the tests.
+const runArgsEnv = "STREAM_FIRST_RUN_ARGS"
+
+func TestMain(m *testing.M) {
+	if args := os.Getenv(runArgsEnv); args != "" {
+		os.Args = append(os.Args[:1], strings.Fields(args)...)
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// Run the program in a child process, and return its report.
+func runReport(t *testing.T, args string) string {
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), runArgsEnv+"="+args+" -report "+reportFile)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	report, err := ioutil.ReadFile(reportFile)
+	require.NoError(t, err)
+	return string(report)
+}
+
+func TestSeed(t *testing.T) {
+	t.Run("Simulated runs with the same seed have the same report", func(t *testing.T) {
+		// Few couriers and a small buffer, so that orders expire, get evicted and events queue up.
+		args := "-headless -clock sim -seed 9 -max-orders 100 -rate 30 -couriers 2 -buffer 3"
+		assert.Equal(t, runReport(t, args), runReport(t, args))
+	})
+}
@@ -1,6 +1,14 @@
 package mocks
 
-import "github.com/stretchr/testify/mock"
+import (
+	"stream-first/common"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
 
 type MockPubsub struct {
 	mock.Mock
@@ -14,6 +22,33 @@ func (ps *MockPubsub) Pub(msg interface{}, topics ...string) {
 	ps.Called(msg, topics)
 }
 
+// Record accepts every event published, and passes it on the returned channel.
+func (ps *MockPubsub) Record() chan interface{} {
+	pubCh := make(chan interface{}, 10)
+	ps.On("Pub", mock.Anything, mock.Anything).Run(func(args mock.Arguments) { pubCh <- args.Get(0) })
+	return pubCh
+}
+
+// Next returns the next event recorded on pubCh, and fails the test if none is published within a second.
+func Next(t *testing.T, pubCh chan interface{}) interface{} {
+	select {
+	case msg := <-pubCh:
+		return msg
+	case <-time.After(time.Second):
+		require.Fail(t, "nothing published")
+		return nil
+	}
+}
+
+// None fails the test if an event is recorded on pubCh shortly.
+func None(t *testing.T, pubCh chan interface{}) {
+	select {
+	case msg := <-pubCh:
+		assert.Fail(t, "unexpected event", "%#v", msg)
+	case <-time.After(common.Seconds(10 * common.SchedulerDelay)):
+	}
+}
+
 type MockRand struct {
 	MockResult float64
 }
@@ -0,0 +1,269 @@
+package natsbus
+
+// The natsbus package carries events between processes over a NATS server, so that services can run as separate
+// processes.  Events travel as json encoded common.Envelope messages, one subject per topic.  Only the core NATS
+// protocol is used, without authentication or TLS.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"stream-first/common"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	serviceName = "NATSBus"
+	// Subjects are the topics under this prefix.
+	subjectPrefix = "stream-first."
+	defaultPort   = "4222"
+	// How long Flush waits for the server.
+	flushTimeout = 5 * time.Second
+	// Largest message payload, when the server doesn't tell its own.  Also the one of the stand-in Server.
+	defaultMaxPayload = 1 << 20
+)
+
+// Bus is a common.PubsubInterface backed by a NATS server.  Like cskr/pubsub, each subscription gets one channel for
+// all its topics, and delivery blocks while the channel is full.  Events published by a process are received in
+// publishing order, events of different processes may interleave.
+type Bus struct {
+	conn     net.Conn
+	capacity int
+	// Largest message payload accepted by the server, and by the bus from the server.
+	maxPayload int
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+
+	mu sync.Mutex
+	// Subscription channels, by subscription ID.
+	subs    map[int]chan interface{}
+	nextSID int
+	// Flush calls waiting for the server's PONG, in the order of their PINGs.
+	pongs []chan bool
+	// The first connection error.  The bus doesn't reconnect.
+	err    error
+	closed bool
+}
+
+// Dial connects to the NATS server at rawURL, for example "nats://localhost:4222".  Subscription channels hold
+// capacity events.
+func Dial(rawURL string, capacity int) (b *Bus, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "bus url")
+	}
+	if u.Scheme != "nats" {
+		return nil, errors.Errorf("bus url %q: scheme must be nats", rawURL)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "bus")
+	}
+
+	r := bufio.NewReader(conn)
+	// The server introduces itself first.
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		_ = conn.Close()
+		return nil, errors.Errorf("bus %v: expected INFO from the server, got %q (%v)", addr, line, err)
+	}
+	var info struct {
+		MaxPayload int `json:"max_payload"`
+	}
+	if err = json.Unmarshal([]byte(line[len("INFO "):]), &info); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrapf(err, "bus %v: INFO", addr)
+	}
+	if info.MaxPayload <= 0 {
+		info.MaxPayload = defaultMaxPayload
+	}
+	b = &Bus{conn: conn, capacity: capacity, maxPayload: info.MaxPayload, w: bufio.NewWriter(conn),
+		subs: map[int]chan interface{}{}}
+	if err = b.send(`CONNECT {"verbose":false,"pedantic":false,"name":"stream-first","lang":"go"}` + "\r\n"); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	go b.read(r)
+	return b, nil
+}
+
+// Close disconnects from the server.  Subscription channels stay open, but receive nothing more.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	return b.conn.Close()
+}
+
+// Err returns the error that broke the connection, if any.
+func (b *Bus) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// Pub publishes msg on each of the topics.  Events that can't be put in an envelope are reported and dropped.
+func (b *Bus) Pub(msg interface{}, topics ...string) {
+	envelope, err := common.NewEnvelope(msg)
+	var data []byte
+	if err == nil {
+		data, err = json.Marshal(envelope)
+	}
+	if err == nil && len(data) > b.maxPayload {
+		err = errors.Errorf("%v bytes, the server takes at most %v", len(data), b.maxPayload)
+	}
+	if err != nil {
+		err = errors.Wrap(err, "event not published")
+		// Reporting a diagnostic message that can't be published would loop.
+		if _, ok := msg.(*common.DiagEvent); ok {
+			_, _ = fmt.Fprintf(os.Stderr, "%v: %v\n", serviceName, err)
+			return
+		}
+		common.Diag(b, serviceName, common.Error, "", err)
+		return
+	}
+	var sb strings.Builder
+	for _, topic := range topics {
+		_, _ = fmt.Fprintf(&sb, "PUB %v%v %v\r\n%s\r\n", subjectPrefix, topic, len(data), data)
+	}
+	if err := b.send(sb.String()); err != nil {
+		b.fail(err)
+	}
+}
+
+// Sub subscribes to the topics, and returns the channel their events are delivered on.
+func (b *Bus) Sub(topics ...string) chan interface{} {
+	ch := make(chan interface{}, b.capacity)
+	var sb strings.Builder
+	b.mu.Lock()
+	for _, topic := range topics {
+		b.nextSID++
+		b.subs[b.nextSID] = ch
+		_, _ = fmt.Fprintf(&sb, "SUB %v%v %v\r\n", subjectPrefix, topic, b.nextSID)
+	}
+	b.mu.Unlock()
+	if err := b.send(sb.String()); err != nil {
+		b.fail(err)
+	}
+	return ch
+}
+
+// Flush waits until the server has processed everything sent so far.  Events published after Flush returns are
+// received by the subscriptions made before it was called, whatever process publishes them.
+func (b *Bus) Flush() error {
+	pong := make(chan bool)
+	b.mu.Lock()
+	b.pongs = append(b.pongs, pong)
+	b.mu.Unlock()
+	if err := b.send("PING\r\n"); err != nil {
+		return err
+	}
+	select {
+	case <-pong:
+		return nil
+	case <-time.After(flushTimeout):
+		return errors.New("bus: flush timed out")
+	}
+}
+
+// Write protocol lines to the server.
+func (b *Bus) send(lines string) error {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	if _, err := b.w.WriteString(lines); err != nil {
+		return errors.Wrap(err, "bus")
+	}
+	return errors.Wrap(b.w.Flush(), "bus")
+}
+
+// Read messages from the server, and deliver them to the subscriptions.
+func (b *Bus) read(r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			b.fail(errors.Wrap(err, "bus"))
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "MSG":
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			if len(fields) < 4 {
+				b.fail(errors.Errorf("bus: malformed message %q", line))
+				return
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || size < 0 || size > b.maxPayload {
+				b.fail(errors.Errorf("bus: malformed message %q", line))
+				// The rest of the stream can't be trusted.
+				_ = b.conn.Close()
+				return
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				b.fail(errors.Wrap(err, "bus"))
+				return
+			}
+			sid, _ := strconv.Atoi(fields[2])
+			b.deliver(sid, payload[:size])
+		case "PING":
+			if err := b.send("PONG\r\n"); err != nil {
+				b.fail(err)
+				return
+			}
+		case "PONG":
+			b.mu.Lock()
+			if len(b.pongs) > 0 {
+				close(b.pongs[0])
+				b.pongs = b.pongs[1:]
+			}
+			b.mu.Unlock()
+		case "-ERR":
+			common.Diag(b, serviceName, common.Error, "",
+				errors.Errorf("bus: server error %v", strings.Join(fields[1:], " ")))
+		}
+		// INFO and +OK need no answer.
+	}
+}
+
+func (b *Bus) deliver(sid int, data []byte) {
+	b.mu.Lock()
+	ch, ok := b.subs[sid]
+	b.mu.Unlock()
+	if !ok {
+		return
+	}
+	var envelope common.Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		common.Diag(b, serviceName, common.Error, "", errors.Wrap(err, "event not delivered"))
+		return
+	}
+	ch <- envelope.Payload
+}
+
+// Keep the first connection error.  Diagnostics can't go through a broken connection, so it goes to stderr.
+func (b *Bus) fail(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil && !b.closed {
+		b.err = err
+		_, _ = fmt.Fprintf(os.Stderr, "%v: %v\n", serviceName, err)
+	}
+}
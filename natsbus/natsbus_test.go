@@ -0,0 +1,132 @@
+package natsbus_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"stream-first/common"
+	"stream-first/natsbus"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newServer(t *testing.T) *natsbus.Server {
+	s, err := natsbus.NewServer("127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = s.Serve() }()
+	return s
+}
+
+func dial(t *testing.T, s *natsbus.Server) *natsbus.Bus {
+	b, err := natsbus.Dial(s.URL(), 10)
+	require.NoError(t, err)
+	return b
+}
+
+func receive(t *testing.T, ch chan interface{}) interface{} {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		require.Fail(t, "no event received")
+		return nil
+	}
+}
+
+func TestBus(t *testing.T) {
+	s := newServer(t)
+	defer func() { _ = s.Close() }()
+	order := common.Order{ID: uuid.New(), Name: "Pizza", Temp: "hot", ShelfLife: 300, DecayRate: 0.45}
+	dt := time.Now().UTC().Round(0)
+
+	t.Run("Events cross processes in publishing order, on a single channel per subscription", func(t *testing.T) {
+		publisher, subscriber := dial(t, s), dial(t, s)
+		defer func() { _ = publisher.Close() }()
+		defer func() { _ = subscriber.Close() }()
+		ch := subscriber.Sub(common.ShelvedTopic, common.PickupTopic)
+		require.NoError(t, subscriber.Flush())
+
+		events := []interface{}{
+			&common.ShelvedEvent{Dt: dt, Order: order, Shelf: "hot"},
+			&common.ValueEvent{Dt: dt, Order: order, Shelf: "hot", Value: 10, NormValue: 0.5},
+			&common.PickupEvent{Dt: dt, Order: order},
+		}
+		publisher.Pub(events[0], common.ShelvedTopic)
+		publisher.Pub(events[1], common.ValueTopic)
+		publisher.Pub(events[2], common.PickupTopic)
+		assert.Equal(t, events[0], receive(t, ch))
+		assert.Equal(t, events[2], receive(t, ch))
+	})
+	t.Run("A process receives its own events", func(t *testing.T) {
+		b := dial(t, s)
+		defer func() { _ = b.Close() }()
+		ch := b.Sub(common.ExpiredTopic)
+		b.Pub(&common.ExpiredEvent{Dt: dt, Order: order}, common.ExpiredTopic)
+		assert.Equal(t, &common.ExpiredEvent{Dt: dt, Order: order}, receive(t, ch))
+	})
+	t.Run("Events without an envelope are reported instead of published", func(t *testing.T) {
+		b := dial(t, s)
+		defer func() { _ = b.Close() }()
+		ch := b.Sub(common.UserRequestTopic, common.DiagTopic)
+		b.Pub("pausePickup", common.UserRequestTopic)
+		e, ok := receive(t, ch).(*common.DiagEvent)
+		require.True(t, ok)
+		assert.Equal(t, common.Error, e.Severity)
+		assert.Contains(t, e.Message, "unregistered event type string")
+	})
+	t.Run("A broken connection is kept as the bus error", func(t *testing.T) {
+		other := newServer(t)
+		b := dial(t, other)
+		require.NoError(t, other.Close())
+		assert.Eventually(t, func() bool { return b.Err() != nil }, time.Second, 10*time.Millisecond)
+	})
+	t.Run("Messages larger than the server takes are reported instead of published", func(t *testing.T) {
+		b := dial(t, s)
+		defer func() { _ = b.Close() }()
+		ch := b.Sub(common.DiagTopic)
+		b.Pub(&common.ShelvedEvent{Dt: dt, Order: common.Order{Name: strings.Repeat("x", 1<<20)}}, common.ShelvedTopic)
+		e, ok := receive(t, ch).(*common.DiagEvent)
+		require.True(t, ok)
+		assert.Contains(t, e.Message, "the server takes at most")
+		assert.NoError(t, b.Err())
+	})
+	t.Run("A message size out of bounds closes the connection", func(t *testing.T) {
+		for _, size := range []int{-1, 1<<20 + 1} {
+			// The server refuses it.
+			conn, err := net.Dial("tcp", s.Addr())
+			require.NoError(t, err)
+			_, err = fmt.Fprintf(conn, "PUB stream-first.pickup %v\r\n", size)
+			require.NoError(t, err)
+			replies, err := ioutil.ReadAll(conn)
+			require.NoError(t, err)
+			assert.Contains(t, string(replies), "-ERR 'Maximum Payload Violation'", size)
+			_ = conn.Close()
+
+			// And so does the bus.
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			go func() {
+				conn, err := listener.Accept()
+				if err == nil {
+					_, _ = fmt.Fprintf(conn, "INFO {\"max_payload\":%v}\r\n", 1<<20)
+					_, _ = fmt.Fprintf(conn, "MSG stream-first.pickup 1 %v\r\n", size)
+					_, _ = ioutil.ReadAll(conn)
+					_ = conn.Close()
+				}
+			}()
+			b, err := natsbus.Dial("nats://"+listener.Addr().String(), 10)
+			require.NoError(t, err)
+			assert.Eventually(t, func() bool { return b.Err() != nil }, time.Second, 10*time.Millisecond, size)
+			_ = listener.Close()
+		}
+	})
+	t.Run("Only nats urls are accepted", func(t *testing.T) {
+		_, err := natsbus.Dial("redis://"+s.Addr(), 10)
+		assert.Error(t, err)
+	})
+}
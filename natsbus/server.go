@@ -0,0 +1,192 @@
+package natsbus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Server is a minimal stand-in for a NATS server, for tests and for runs without one.  It implements the part of the
+// core protocol the Bus uses: publishing, and subscribing to exact subjects or to subjects ending in the ">"
+// wildcard.  Messages from a client are delivered in the order the client sent them.
+type Server struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	clients map[*serverClient]bool
+}
+
+// A connected client.
+type serverClient struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+
+	mu sync.Mutex
+	// Subscribed subjects, by subscription ID.
+	subs map[string]string
+}
+
+// NewServer listens on addr, for example "127.0.0.1:4222".  A zero port picks a free one, see Addr.
+func NewServer(addr string) (s *Server, err error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "bus server")
+	}
+	return &Server{listener: listener, clients: map[*serverClient]bool{}}, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// URL returns the url for Dial.
+func (s *Server) URL() string {
+	return "nats://" + s.Addr()
+}
+
+// Serve accepts clients until the server is closed.
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return err
+		}
+		c := &serverClient{conn: conn, w: bufio.NewWriter(conn), subs: map[string]string{}}
+		s.mu.Lock()
+		s.clients[c] = true
+		s.mu.Unlock()
+		go s.serve(c)
+	}
+}
+
+// Close stops accepting clients, and disconnects the connected ones.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		_ = c.conn.Close()
+	}
+	return err
+}
+
+func (s *Server) serve(c *serverClient) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		_ = c.conn.Close()
+	}()
+	info := `INFO {"server_id":"stream-first-stand-in","version":"0.0.0","proto":0,"max_payload":%v}` + "\r\n"
+	if c.send(fmt.Sprintf(info, defaultMaxPayload)) != nil {
+		return
+	}
+
+	r := bufio.NewReader(c.conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONNECT", "PONG":
+		case "PING":
+			err = c.send("PONG\r\n")
+		case "SUB":
+			// SUB <subject> [queue group] <sid>
+			if len(fields) < 3 {
+				err = c.send("-ERR 'Invalid Subscription'\r\n")
+				break
+			}
+			c.mu.Lock()
+			c.subs[fields[len(fields)-1]] = fields[1]
+			c.mu.Unlock()
+		case "UNSUB":
+			if len(fields) >= 2 {
+				c.mu.Lock()
+				delete(c.subs, fields[1])
+				c.mu.Unlock()
+			}
+		case "PUB":
+			// PUB <subject> [reply-to] <#bytes>
+			var size int
+			if len(fields) >= 3 {
+				size, err = strconv.Atoi(fields[len(fields)-1])
+			}
+			if len(fields) < 3 || err != nil {
+				_ = c.send("-ERR 'Unknown Protocol Operation'\r\n")
+				return
+			}
+			if size < 0 || size > defaultMaxPayload {
+				_ = c.send("-ERR 'Maximum Payload Violation'\r\n")
+				return
+			}
+			payload := make([]byte, size+2)
+			if _, err = io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.publish(fields[1], payload[:size])
+		default:
+			_ = c.send("-ERR 'Unknown Protocol Operation'\r\n")
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Deliver a message to every matching subscription.
+func (s *Server) publish(subject string, payload []byte) {
+	s.mu.Lock()
+	clients := make([]*serverClient, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+	for _, c := range clients {
+		var sb strings.Builder
+		c.mu.Lock()
+		for sid, pattern := range c.subs {
+			if matches(pattern, subject) {
+				_, _ = fmt.Fprintf(&sb, "MSG %v %v %v\r\n%s\r\n", subject, sid, len(payload), payload)
+			}
+		}
+		c.mu.Unlock()
+		if sb.Len() > 0 {
+			// A client that can't be written to is dropped by its own reader.
+			_ = c.send(sb.String())
+		}
+	}
+}
+
+func (c *serverClient) send(lines string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.w.WriteString(lines); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// Tell whether a subject matches a subscription pattern.  Patterns are exact subjects, or end in ">" to match any
+// subjects under a prefix.
+func matches(pattern string, subject string) bool {
+	if strings.HasSuffix(pattern, ">") {
+		return strings.HasPrefix(subject, strings.TrimSuffix(pattern, ">"))
+	}
+	return pattern == subject
+}
@@ -4,72 +4,144 @@ package ordersender
 // from the sample file, and publishes them at random intervals.
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
+	"math"
 	"stream-first/common"
+	"stream-first/shelflife"
 	"stream-first/ui/userrequests"
+	"sync/atomic"
 	"time"
 
-	"github.com/cskr/pubsub"
 	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/rand"
 	"gonum.org/v1/gonum/stat/distuv"
 )
 
-//noinspection NonAsciiCharacters
 const (
 	serviceName = "OrderSender"
-	ordersFile  = "data/orders.json"
-	// Yes, Go does support non ascii identifiers :)
-	λ = 3.25
 )
 
-var paused bool
+// What user requests change while orders are being sent.  Set by Run, and read by pubOrders in another goroutine.
+type settings struct {
+	paused atomic.Bool
+	// Mean number of new orders per second, as float64 bits.
+	arrivalRate atomic.Uint64
+}
 
-// Run simulates a new order source.  It reads orders from a data file, and publishes them in random intervals.
-func Run(ps *pubsub.PubSub) {
+// Run simulates a new order source.  It publishes orders, as loaded by LoadOrders, over and over in random intervals,
+// averaging λ orders per second until the user requests another rate.  Yes, Go does support non ascii identifiers :)
+// Publishing stops after maxOrders orders, unless maxOrders is 0, or once ctx is done.  Arrival times and order IDs
+// are derived from seed.
+//noinspection NonAsciiCharacters
+func Run(ctx context.Context, ps common.PubsubInterface, clock common.Clock, seed uint64, orders []common.Order,
+	λ float64, maxOrders int) {
 	userRequestCh := ps.Sub(common.UserRequestTopic)
 	// Allow time for other components to subscribe before starting to publish.
 	time.Sleep(common.Seconds(common.SchedulerDelay))
 
 	common.Diag(ps, serviceName, common.Info, "Service started.", nil)
-	go pubOrders(ps)
+	var s settings
+	s.setArrivalRate(λ)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pubOrders(ctx, ps, clock, &s, seed, orders, maxOrders)
+	}()
 	for {
-		msg := <-userRequestCh
-		userRequest, ok := msg.(string)
+		var msg interface{}
+		select {
+		case msg = <-userRequestCh:
+		case <-ctx.Done():
+			<-done
+			return
+		}
+		userRequest, ok := msg.(*common.UserRequestEvent)
 		if !ok {
-			panic("could not coerce")
+			common.Diag(ps, serviceName, common.Error, common.CoerceErrorMessage(msg, userRequest), nil)
+			continue
 		}
-		switch userRequest {
+		switch userRequest.Request {
 		case userrequests.PauseIncomingOrders:
-			paused = true
+			s.paused.Store(true)
 		case userrequests.ResumeIncomingOrders:
-			paused = false
+			s.paused.Store(false)
+		case userrequests.SetArrivalRate:
+			s.setArrivalRate(userRequest.Rate)
+			common.Diag(ps, serviceName, common.Info,
+				fmt.Sprintf("Arrival rate set to %v orders per second.", userRequest.Rate), nil)
 		}
 	}
 }
 
-func pubOrders(ps *pubsub.PubSub) {
+func (s *settings) setArrivalRate(rate float64) {
+	s.arrivalRate.Store(math.Float64bits(rate))
+}
+
+func (s *settings) getArrivalRate() float64 {
+	return math.Float64frombits(s.arrivalRate.Load())
+}
+
+// LoadOrders reads the orders of the sample file format, a json array, from ordersFile.  There must be at least one
+// order, and every decay spec must be valid.
+func LoadOrders(ordersFile string) ([]common.Order, error) {
 	raw, err := ioutil.ReadFile(ordersFile)
 	if err != nil {
-		log.Fatal(err)
+		return nil, errors.Wrap(err, "orders")
+	}
+	var orders []common.Order
+	if err := json.Unmarshal(raw, &orders); err != nil {
+		return nil, errors.Wrap(err, ordersFile)
 	}
-	var data []common.Order
-	if err := json.Unmarshal(raw, &data); err != nil {
-		log.Fatal(err)
+	if len(orders) == 0 {
+		return nil, errors.Errorf("%v: no orders", ordersFile)
+	}
+	for _, order := range orders {
+		if _, err := shelflife.NewDecayModel(order.Decay); err != nil {
+			return nil, errors.Wrapf(err, "%v: order %q", ordersFile, order.Name)
+		}
 	}
+	return orders, nil
+}
 
-	p := distuv.Exponential{Rate: λ}
+func pubOrders(ctx context.Context, ps common.PubsubInterface, clock common.Clock, s *settings, seed uint64,
+	orders []common.Order, maxOrders int) {
+	src := common.NewSource(seed, serviceName)
+	// Intervals are drawn at rate 1 and scaled, so that the rate can change between draws without touching the
+	// sequence drawn from seed.
+	p := distuv.Exponential{Rate: 1, Src: src}
+	// Order IDs are random too, so they are drawn from a separate source to keep arrival times independent of them.
+	idReader := rand.New(common.NewSource(seed, serviceName+"/IDs"))
 
+	sent := 0
 	for {
-		for _, order := range data {
-			numSeconds := p.Rand()
-			timer := time.NewTimer(common.Seconds(numSeconds))
-			now := <-timer.C
-			order.ID = uuid.New()
+		for _, order := range orders {
+			numSeconds := p.Rand() / s.getArrivalRate()
+			timer := clock.NewTimer(common.Seconds(numSeconds))
+			var now time.Time
+			select {
+			case now = <-timer.C():
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+			id, err := uuid.NewRandomFromReader(idReader)
+			if err != nil {
+				common.Diag(ps, serviceName, common.Error, "", errors.Wrap(err, "order skipped"))
+				continue
+			}
+			order.ID = id
 			e := &common.NewOrderEvent{Dt: now, Order: order}
-			if !paused {
+			if !s.paused.Load() {
 				ps.Pub(e, common.NewOrderTopic)
+				sent++
+				if sent == maxOrders {
+					common.Diag(ps, serviceName, common.Info, fmt.Sprintf("Sent all %v orders.", sent), nil)
+					return
+				}
 			}
 		}
 	}
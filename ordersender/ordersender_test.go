@@ -0,0 +1,37 @@
+package ordersender_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"stream-first/ordersender"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeOrders(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "orders.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestLoadOrders(t *testing.T) {
+	t.Run("The sample orders load", func(t *testing.T) {
+		orders, err := ordersender.LoadOrders("../data/orders.json")
+		require.NoError(t, err)
+		assert.NotEmpty(t, orders)
+	})
+	t.Run("Files that can't be sent are rejected", func(t *testing.T) {
+		for _, content := range []string{
+			`[]`,
+			`{"name": "Pizza"}`,
+			`[{"name": "Pizza", "temp": "hot", "shelfLife": 300, "decayRate": 0.45, "decay": {"model": "exponential"}}]`,
+		} {
+			_, err := ordersender.LoadOrders(writeOrders(t, content))
+			assert.Error(t, err, content)
+		}
+		_, err := ordersender.LoadOrders(filepath.Join(t.TempDir(), "missing.json"))
+		assert.Error(t, err)
+	})
+}
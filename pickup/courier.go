@@ -0,0 +1,269 @@
+package pickup
+
+import (
+	"context"
+	"stream-first/common"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Courier states
+const (
+	idle = "idle"
+	// On the way to the kitchen.
+	enRoute = "enRoute"
+	// At the kitchen, waiting for the order to be on a shelf, or for pickups to resume.
+	waiting = "waiting"
+	// Taking the order to the customer.
+	delivering = "delivering"
+)
+
+type courier struct {
+	id    int
+	state string
+	// The order the courier was dispatched for, unless idle.  Couriers may trade orders, see fleet.pickup.
+	order common.Order
+	// Counts the courier's trips, so that the end of a cancelled trip is ignored.
+	trip       int
+	cancelTrip context.CancelFunc
+}
+
+// The end of a courier's trip, to the kitchen or to the customer.
+type tripEnd struct {
+	courier *courier
+	trip    int
+	at      time.Time
+}
+
+// The couriers and the orders they pick up.  Only used by the service goroutine, except for trip timers.
+type fleet struct {
+	ctx      context.Context
+	travel   common.RandInterface
+	strategy PickupStrategy
+	ps       common.PubsubInterface
+	clock    common.Clock
+	// Couriers that are available, longest available first.
+	idle []*courier
+	// Couriers at the kitchen, longest waiting first.
+	waiting []*courier
+	// Orders waiting for a courier, in arrival order.
+	backlog []common.Order
+	// Couriers by the ID of the order they were dispatched for.
+	assigned map[uuid.UUID]*courier
+	// Orders that are on the shelves and not picked up, in shelving order.
+	ready []*ReadyOrder
+	// Couriers at the kitchen wait while pickups are paused.
+	paused bool
+
+	tripEndCh chan tripEnd
+	// Counts the goroutines timing trips.
+	trips sync.WaitGroup
+}
+
+func newFleet(ctx context.Context, travel common.RandInterface, strategy PickupStrategy, ps common.PubsubInterface,
+	clock common.Clock, couriers int) *fleet {
+	f := &fleet{
+		ctx:       ctx,
+		travel:    travel,
+		strategy:  strategy,
+		ps:        ps,
+		clock:     clock,
+		assigned:  map[uuid.UUID]*courier{},
+		tripEndCh: make(chan tripEnd),
+	}
+	for id := 1; id <= couriers; id++ {
+		f.idle = append(f.idle, &courier{id: id, state: idle})
+	}
+	return f
+}
+
+// Dispatch an available courier for a new order, or queue the order until one is available.
+func (f *fleet) orderArrived(order common.Order, now time.Time) {
+	if len(f.idle) == 0 {
+		if len(f.backlog) == 0 {
+			common.Diag(f.ps, serviceName, common.Warning, "All couriers are busy, orders wait for a courier.", nil)
+		}
+		f.backlog = append(f.backlog, order)
+		return
+	}
+	c := f.idle[0]
+	f.idle = f.idle[1:]
+	f.dispatch(c, order, now)
+}
+
+func (f *fleet) dispatch(c *courier, order common.Order, now time.Time) {
+	c.state, c.order = enRoute, order
+	f.assigned[order.ID] = c
+	travelTime := common.Seconds(f.travel.Rand())
+	f.ps.Pub(&common.CourierDispatchedEvent{Dt: now, CourierID: c.id, OrderID: order.ID, DueAt: now.Add(travelTime)},
+		common.CourierDispatchedTopic)
+	f.startTrip(c, travelTime)
+}
+
+// Time a trip of the courier, and pass its end to the service goroutine.
+func (f *fleet) startTrip(c *courier, d time.Duration) {
+	c.trip++
+	var tripCtx context.Context
+	tripCtx, c.cancelTrip = context.WithCancel(f.ctx)
+	timer := f.clock.NewTimer(d)
+	f.trips.Add(1)
+	go func(trip int) {
+		defer f.trips.Done()
+		select {
+		case at := <-timer.C():
+			select {
+			case f.tripEndCh <- tripEnd{courier: c, trip: trip, at: at}:
+			case <-tripCtx.Done():
+			}
+		case <-tripCtx.Done():
+			timer.Stop()
+		}
+	}(c.trip)
+}
+
+func (f *fleet) tripEnded(e tripEnd) {
+	c := e.courier
+	if e.trip != c.trip {
+		return
+	}
+	c.cancelTrip()
+	switch c.state {
+	case enRoute:
+		c.state = waiting
+		f.waiting = append(f.waiting, c)
+		f.ps.Pub(&common.CourierArrivedEvent{Dt: e.at, CourierID: c.id, OrderID: c.order.ID}, common.CourierArrivedTopic)
+		f.pickup(c, e.at)
+	case delivering:
+		f.release(c, e.at)
+	}
+}
+
+// Have a waiting courier pick up the order chosen by the strategy, unless pickups are paused.  Returns whether an
+// order was picked up.
+func (f *fleet) pickup(c *courier, now time.Time) bool {
+	if f.paused {
+		return false
+	}
+	r := f.strategy.Pick(c.order.ID, f.ready)
+	if r == nil {
+		return false
+	}
+	if r.Order.ID != c.order.ID {
+		f.trade(c, r.Order)
+	}
+	f.removeReady(r.Order.ID)
+	f.removeWaiting(c)
+	delete(f.assigned, c.order.ID)
+	f.ps.Pub(&common.PickupEvent{Dt: now, Order: c.order, CourierID: c.id, Strategy: f.strategy.Name()},
+		common.PickupTopic)
+	c.state = delivering
+	f.startTrip(c, common.Seconds(f.travel.Rand()))
+	return true
+}
+
+// Have a courier take over another order.  The courier that was dispatched for it takes over the courier's order in
+// exchange.  If no courier was dispatched for it yet, the courier's order waits for a courier instead, ahead of the
+// others since it arrived before them.
+func (f *fleet) trade(c *courier, order common.Order) {
+	if other, ok := f.assigned[order.ID]; ok {
+		other.order = c.order
+		f.assigned[c.order.ID] = other
+	} else {
+		f.removeBacklogged(order.ID)
+		delete(f.assigned, c.order.ID)
+		f.backlog = append([]common.Order{c.order}, f.backlog...)
+	}
+	c.order = order
+	f.assigned[order.ID] = c
+}
+
+// Offer a newly shelved order to the waiting couriers, longest waiting first.
+func (f *fleet) orderShelved(order common.Order, now time.Time) {
+	f.ready = append(f.ready, &ReadyOrder{Order: order, ShelvedAt: now, NormValue: 1})
+	for _, c := range append([]*courier(nil), f.waiting...) {
+		if f.pickup(c, now) {
+			return
+		}
+	}
+}
+
+func (f *fleet) valueChanged(orderID uuid.UUID, normValue float32) {
+	for _, r := range f.ready {
+		if r.Order.ID == orderID {
+			r.NormValue = normValue
+			return
+		}
+	}
+}
+
+// Forget an order that expired or was thrown away, and recall its courier.
+func (f *fleet) orderGone(orderID uuid.UUID, now time.Time) {
+	f.removeReady(orderID)
+	if f.removeBacklogged(orderID) {
+		return
+	}
+	c, ok := f.assigned[orderID]
+	if !ok {
+		return
+	}
+	delete(f.assigned, orderID)
+	switch c.state {
+	case enRoute:
+		c.cancelTrip()
+		// Ignore the end of the trip if it was already under way to the service goroutine.
+		c.trip++
+	case waiting:
+		f.removeWaiting(c)
+	}
+	f.release(c, now)
+}
+
+func (f *fleet) removeReady(orderID uuid.UUID) {
+	for i, r := range f.ready {
+		if r.Order.ID == orderID {
+			f.ready = append(f.ready[:i:i], f.ready[i+1:]...)
+			return
+		}
+	}
+}
+
+func (f *fleet) removeBacklogged(orderID uuid.UUID) bool {
+	for i, order := range f.backlog {
+		if order.ID == orderID {
+			f.backlog = append(f.backlog[:i:i], f.backlog[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fleet) removeWaiting(c *courier) {
+	for i, w := range f.waiting {
+		if w == c {
+			f.waiting = append(f.waiting[:i:i], f.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// Make a courier available, and dispatch it for the longest waiting order if any.
+func (f *fleet) release(c *courier, now time.Time) {
+	c.state, c.order = idle, common.Order{}
+	if len(f.backlog) == 0 {
+		f.idle = append(f.idle, c)
+		return
+	}
+	order := f.backlog[0]
+	f.backlog = f.backlog[1:]
+	f.dispatch(c, order, now)
+}
+
+// Resume pickups, and have the waiting couriers pick orders up, longest waiting first.
+func (f *fleet) resume(now time.Time) {
+	f.paused = false
+	for _, c := range append([]*courier(nil), f.waiting...) {
+		f.pickup(c, now)
+	}
+}
@@ -1,15 +1,17 @@
 package pickup
 
-// The pickup service generates pickups for newly shelved orders, and cancels outstanding pickups for
-// expire orders.
+// The pickup service models the courier fleet.  A courier is dispatched to the kitchen when an order arrives, waits
+// there until an order is on a shelf, picks it up and delivers it, and is then available for the next order.  Which
+// order a courier picks up is up to the pickup strategy: the one it was dispatched for, or any ready order.  When
+// all couriers are busy, orders wait for one to become available.  Pickup times thus follow from travel times and
+// courier availability.  Couriers are recalled when their order expires or is thrown away.
 
 import (
+	"context"
 	"stream-first/common"
 	"stream-first/ui/userrequests"
 	"time"
 
-	"github.com/cskr/pubsub"
-	"github.com/orcaman/concurrent-map"
 	"gonum.org/v1/gonum/stat/distuv"
 )
 
@@ -17,79 +19,59 @@ const (
 	serviceName = "Pickup"
 )
 
-// When set, pickups are paused.
-var paused bool
-
-func Run(ps *pubsub.PubSub) {
-
-	shelvedCh := ps.Sub(common.ShelvedTopic)
-	expiredCh := ps.Sub(common.ExpiredTopic)
-	userRequestCh := ps.Sub(common.UserRequestTopic)
+// Run dispatches a fleet of couriers to pick orders up until ctx is done.  Each trip, to the kitchen or delivering an
+// order, takes between minSeconds and maxSeconds.  Trip times are derived from seed.  Couriers at the kitchen pick up
+// the orders chosen by strategy.
+func Run(ctx context.Context, ps common.PubsubInterface, clock common.Clock, seed uint64, couriers int,
+	minSeconds float64, maxSeconds float64, strategy PickupStrategy) {
+	// A single subscription keeps events in publishing order, so that orders arrive before they are shelved.
+	ch := ps.Sub(common.NewOrderTopic, common.ShelvedTopic, common.ExpiredTopic, common.WasteTopic,
+		common.ValueTopic, common.UserRequestTopic)
 
 	// Allow time for other components to subscribe before starting to publish.
 	time.Sleep(common.Seconds(common.SchedulerDelay))
 	common.Diag(ps, serviceName, common.Info, "Service started.", nil)
 
-	p := distuv.Uniform{Min: 2, Max: 10}
+	travel := distuv.Uniform{Min: minSeconds, Max: maxSeconds, Src: common.NewSource(seed, serviceName)}
 
-	Run0(p, ps, shelvedCh, expiredCh, userRequestCh, nil)
+	Run0(ctx, travel, strategy, ps, clock, couriers, ch)
 }
 
-// Run0 is a testable version of the service.  It allows injecting mocks for pub/sub and Rand calls.
-func Run0(p common.RandInterface, ps common.PubsubInterface,
-	shelvedCh chan interface{}, expiredCh chan interface{}, userRequestCh chan interface{}, stopCh chan bool) {
-
-	// A thread-safe map storing timers for pending order pickups.
-	pendingPickups := cmap.New()
-
+// Run0 is a testable version of the service.  It allows injecting mocks for pub/sub, trip time draws and the clock.
+// It returns once ctx is done and the trips in progress are cancelled.
+func Run0(ctx context.Context, travel common.RandInterface, strategy PickupStrategy, ps common.PubsubInterface,
+	clock common.Clock, couriers int, ch chan interface{}) {
+	f := newFleet(ctx, travel, strategy, ps, clock, couriers)
 	for {
 		select {
-		case msg := <-shelvedCh:
-			e, ok := msg.(*common.ShelvedEvent)
-			if !ok {
+		case msg := <-ch:
+			switch e := msg.(type) {
+			case *common.NewOrderEvent:
+				f.orderArrived(e.Order, e.Dt)
+			case *common.ShelvedEvent:
+				f.orderShelved(e.Order, e.Dt)
+			case *common.ExpiredEvent:
+				f.orderGone(e.Order.ID, e.Dt)
+			// Evicted orders are only reported on the waste topic.
+			case *common.WasteEvent:
+				f.orderGone(e.Order.ID, e.Dt)
+			case *common.ValueEvent:
+				f.valueChanged(e.Order.ID, e.NormValue)
+			case *common.UserRequestEvent:
+				switch e.Request {
+				case userrequests.PausePickup:
+					f.paused = true
+				case userrequests.ResumePickup:
+					f.resume(clock.Now())
+				}
+			default:
 				common.Diag(ps, serviceName, common.Error, common.CoerceErrorMessage(msg, e), nil)
-				continue
-			}
-			secondsToPickup := p.Rand()
-			timer := time.NewTimer(common.Seconds(secondsToPickup))
-			pickupEvent := &common.PickupEvent{Dt: e.Dt, Order: e.Order}
-			pendingPickups.Set(e.Order.ID.String(), timer)
-			go pickup(ps, pickupEvent, timer, p)
-		case msg := <-expiredCh:
-			e, ok := msg.(*common.ExpiredEvent)
-			if !ok {
-				common.Diag(ps, serviceName, common.Error, common.CoerceErrorMessage(msg, e), nil)
-				continue
-			}
-			orderIDStr := e.Order.ID.String()
-			if timerInterface, ok := pendingPickups.Get(orderIDStr); ok {
-				timer := timerInterface.(*time.Timer)
-				timer.Stop()
-				pendingPickups.Remove(orderIDStr)
 			}
-		case msg := <-userRequestCh:
-			e, ok := msg.(string)
-			if !ok {
-				common.Diag(ps, serviceName, common.Error, common.CoerceErrorMessage(msg, e), nil)
-				continue
-			}
-			switch e {
-			case userrequests.PausePickup:
-				paused = true
-			case userrequests.ResumePickup:
-				paused = false
-			}
-		case <-stopCh:
-			break
+		case e := <-f.tripEndCh:
+			f.tripEnded(e)
+		case <-ctx.Done():
+			f.trips.Wait()
+			return
 		}
 	}
 }
-
-func pickup(ps common.PubsubInterface, pickupEvent *common.PickupEvent, timer *time.Timer, r common.RandInterface) {
-	<-timer.C
-	for paused {
-		secondsToPickup := r.Rand()
-		time.Sleep(common.Seconds(secondsToPickup))
-	}
-	ps.Pub(pickupEvent, common.PickupTopic)
-}
@@ -1,105 +1,175 @@
 package pickup_test
 
 import (
-	"github.com/google/uuid"
-	"github.com/stretchr/testify/mock"
+	"context"
 	"stream-first/common"
 	"stream-first/mocks"
 	"stream-first/pickup"
 	"stream-first/ui/userrequests"
 	"testing"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
 )
 
 var (
-	testOrder       = common.Order{Name: "something yummy", Temp: "some temp", ID: uuid.New(), DecayRate: 10, ShelfLife: 30}
-	secondsToPickup = 0.2231
-	secondsToExpire = 0.1231
+	testOrder  = common.Order{Name: "something yummy", Temp: "some temp", ID: uuid.New(), DecayRate: 10, ShelfLife: 30}
+	otherOrder = common.Order{Name: "something else", Temp: "some temp", ID: uuid.New(), DecayRate: 10, ShelfLife: 30}
+	tripTime   = common.Seconds(0.2231)
 )
 
 func TestRun0(t *testing.T) {
-	rand := &mocks.MockRand{MockResult: secondsToPickup}
-	t.Run("A pickup event is fired after the required duration", func(t *testing.T) {
-		ps, shelvedCh, expiredCh, userRequestCh, stopCh := initParams()
-		go pickup.Run0(rand, ps, shelvedCh, expiredCh, userRequestCh, stopCh)
-
-		ps.On("Pub", mock.Anything, mock.Anything)
-		pubShelved(shelvedCh)
-		// Wait a bit longer than pickup time to allow for gorouting scheduling time
-		time.Sleep(common.Seconds(secondsToPickup + common.SchedulerDelay))
-		pickupAt := time.Now()
-		ps.AssertNumberOfCalls(t, "Pub", 1)
-		ps.AssertCalled(t, "Pub", mock.MatchedBy(func(msg interface{}) bool {
-			pickupEvent := msg.(*common.PickupEvent)
-			return pickupEvent.Order == testOrder && pickupAt.After(pickupEvent.Dt)
-		}), []string{common.PickupTopic})
-		stopCh <- true
+	t.Run("A courier is dispatched for a new order, and picks it up on arrival", func(t *testing.T) {
+		clock, ch, pubCh, stop := start(1, pickup.PickupMatched{})
+		defer stop()
+
+		dispatchedAt := clock.Now()
+		ch <- &common.NewOrderEvent{Dt: dispatchedAt, Order: testOrder}
+		assert.Equal(t, &common.CourierDispatchedEvent{Dt: dispatchedAt, CourierID: 1, OrderID: testOrder.ID,
+			DueAt: dispatchedAt.Add(tripTime)}, mocks.Next(t, pubCh))
+		ch <- &common.ShelvedEvent{Dt: dispatchedAt, Order: testOrder, Shelf: "a shelf"}
+
+		clock.BlockUntil(1)
+		clock.Advance(tripTime - time.Millisecond)
+		mocks.None(t, pubCh)
+		clock.Advance(time.Millisecond)
+		arrivedAt := dispatchedAt.Add(tripTime)
+		assert.Equal(t, &common.CourierArrivedEvent{Dt: arrivedAt, CourierID: 1, OrderID: testOrder.ID}, mocks.Next(t, pubCh))
+		assert.Equal(t, &common.PickupEvent{Dt: arrivedAt, Order: testOrder, CourierID: 1,
+			Strategy: pickup.MatchedStrategy}, mocks.Next(t, pubCh))
 	})
-	t.Run("An expire event circumvents the pickup event", func(t *testing.T) {
-		ps, shelvedCh, expiredCh, userRequestCh, stopCh := initParams()
-		go pickup.Run0(rand, ps, shelvedCh, expiredCh, userRequestCh, stopCh)
-
-		ps.On("Pub", mock.Anything, mock.Anything)
-		pubShelved(shelvedCh)
-		time.Sleep(common.Seconds(common.SchedulerDelay))
-		pubExpired(expiredCh)
-		time.Sleep(common.Seconds(common.SchedulerDelay))
-		ps.AssertNotCalled(t, "Pub", mock.Anything, mock.Anything)
-		stopCh <- true
+	t.Run("A courier waits for an order that is not on a shelf yet", func(t *testing.T) {
+		clock, ch, pubCh, stop := start(1, pickup.PickupMatched{})
+		defer stop()
+
+		ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: testOrder}
+		mocks.Next(t, pubCh)
+		clock.BlockUntil(1)
+		clock.Advance(tripTime)
+		assert.IsType(t, &common.CourierArrivedEvent{}, mocks.Next(t, pubCh))
+		mocks.None(t, pubCh)
+
+		ch <- &common.ShelvedEvent{Dt: clock.Now(), Order: testOrder, Shelf: "a shelf"}
+		assert.Equal(t, &common.PickupEvent{Dt: clock.Now(), Order: testOrder, CourierID: 1,
+			Strategy: pickup.MatchedStrategy}, mocks.Next(t, pubCh))
 	})
-	t.Run("An pickup event is not generated when the service is paused", func(t *testing.T) {
-		ps, shelvedCh, expiredCh, userRequestCh, stopCh := initParams()
-		go pickup.Run0(rand, ps, shelvedCh, expiredCh, userRequestCh, stopCh)
-
-		ps.On("Pub", mock.Anything, mock.Anything)
-
-		userRequestCh <- userrequests.PausePickup
-		pubShelved(shelvedCh)
-		time.Sleep(common.Seconds(secondsToExpire + common.SchedulerDelay))
-		pubExpired(expiredCh)
-		time.Sleep(common.Seconds(secondsToPickup + common.SchedulerDelay))
-		ps.AssertNotCalled(t, "Pub", mock.Anything, mock.Anything)
-		stopCh <- true
+	t.Run("Orders wait for a courier while all are busy", func(t *testing.T) {
+		clock, ch, pubCh, stop := start(1, pickup.PickupMatched{})
+		defer stop()
+
+		ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: testOrder}
+		ch <- &common.ShelvedEvent{Dt: clock.Now(), Order: testOrder, Shelf: "a shelf"}
+		ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: otherOrder}
+		ch <- &common.ShelvedEvent{Dt: clock.Now(), Order: otherOrder, Shelf: "a shelf"}
+		assert.IsType(t, &common.CourierDispatchedEvent{}, mocks.Next(t, pubCh))
+		assert.IsType(t, &common.DiagEvent{}, mocks.Next(t, pubCh))
+		mocks.None(t, pubCh)
+
+		// To the kitchen, and back from the delivery.
+		clock.BlockUntil(1)
+		clock.Advance(tripTime)
+		mocks.Next(t, pubCh)
+		assert.Equal(t, &common.PickupEvent{Dt: clock.Now(), Order: testOrder, CourierID: 1,
+			Strategy: pickup.MatchedStrategy}, mocks.Next(t, pubCh))
+		clock.BlockUntil(1)
+		clock.Advance(tripTime)
+		assert.Equal(t, &common.CourierDispatchedEvent{Dt: clock.Now(), CourierID: 1, OrderID: otherOrder.ID,
+			DueAt: clock.Now().Add(tripTime)}, mocks.Next(t, pubCh))
 	})
-	t.Run("When a shelved event arrives when service is paused, a pickup event fires after service resumed", func(t *testing.T) {
-		ps, shelvedCh, expiredCh, userRequestCh, stopCh := initParams()
-		go pickup.Run0(rand, ps, shelvedCh, expiredCh, userRequestCh, stopCh)
-
-		ps.On("Pub", mock.Anything, mock.Anything)
-
-		userRequestCh <- userrequests.PausePickup
-		pubShelved(shelvedCh)
-		userRequestCh <- userrequests.ResumePickup
-		// Wait a bit longer than pickup time to allow for gorouting scheduling time
-		time.Sleep(common.Seconds(secondsToPickup + common.SchedulerDelay))
-		pickupAt := time.Now()
-		ps.AssertNumberOfCalls(t, "Pub", 1)
-		ps.AssertCalled(t, "Pub", mock.MatchedBy(func(msg interface{}) bool {
-			pickupEvent := msg.(*common.PickupEvent)
-			return pickupEvent.Order == testOrder && pickupAt.After(pickupEvent.Dt)
-		}), []string{common.PickupTopic})
-		stopCh <- true
+	t.Run("A courier is recalled when its order expires or is thrown away", func(t *testing.T) {
+		clock, ch, pubCh, stop := start(1, pickup.PickupMatched{})
+		defer stop()
+
+		ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: testOrder}
+		ch <- &common.ShelvedEvent{Dt: clock.Now(), Order: testOrder, Shelf: "a shelf"}
+		mocks.Next(t, pubCh)
+		ch <- &common.ExpiredEvent{Dt: clock.Now(), Order: testOrder}
+		ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: otherOrder}
+		assert.Equal(t, &common.CourierDispatchedEvent{Dt: clock.Now(), CourierID: 1, OrderID: otherOrder.ID,
+			DueAt: clock.Now().Add(tripTime)}, mocks.Next(t, pubCh))
+		ch <- &common.WasteEvent{Dt: clock.Now(), Order: otherOrder, Reason: common.WasteShelvesFull}
+
+		clock.Advance(tripTime)
+		mocks.None(t, pubCh)
 	})
-}
+	t.Run("Couriers wait while pickups are paused", func(t *testing.T) {
+		clock, ch, pubCh, stop := start(1, pickup.PickupMatched{})
+		defer stop()
 
-func pubShelved(shelvedCh chan interface{}) {
-	shelvedAt := time.Now()
-	shelvedEvent := &common.ShelvedEvent{Order: testOrder, Shelf: "a shelf", Dt: shelvedAt}
-	shelvedCh <- shelvedEvent
-}
+		ch <- &common.UserRequestEvent{Request: userrequests.PausePickup}
+		ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: testOrder}
+		ch <- &common.ShelvedEvent{Dt: clock.Now(), Order: testOrder, Shelf: "a shelf"}
+		mocks.Next(t, pubCh)
+		clock.BlockUntil(1)
+		clock.Advance(tripTime)
+		assert.IsType(t, &common.CourierArrivedEvent{}, mocks.Next(t, pubCh))
+		mocks.None(t, pubCh)
+
+		ch <- &common.UserRequestEvent{Request: userrequests.ResumePickup}
+		assert.Equal(t, &common.PickupEvent{Dt: clock.Now(), Order: testOrder, CourierID: 1,
+			Strategy: pickup.MatchedStrategy}, mocks.Next(t, pubCh))
+	})
+	t.Run("With fifo pickups, couriers trade orders to pick up the one that is ready", func(t *testing.T) {
+		clock, ch, pubCh, stop := start(2, pickup.PickupFIFO{})
+		defer stop()
 
-func pubExpired(expiredCh chan interface{}) {
-	expiredAt := time.Now()
-	expiredEvent := &common.ExpiredEvent{Order: testOrder, Dt: expiredAt}
-	expiredCh <- expiredEvent
+		ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: testOrder}
+		mocks.Next(t, pubCh)
+		clock.BlockUntil(1)
+		clock.Advance(tripTime / 2)
+		ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: otherOrder}
+		ch <- &common.ShelvedEvent{Dt: clock.Now(), Order: otherOrder, Shelf: "a shelf"}
+		mocks.Next(t, pubCh)
+		clock.BlockUntil(2)
+		clock.Advance(tripTime / 2)
+		assert.Equal(t, &common.CourierArrivedEvent{Dt: clock.Now(), CourierID: 1, OrderID: testOrder.ID},
+			mocks.Next(t, pubCh))
+		assert.Equal(t, &common.PickupEvent{Dt: clock.Now(), Order: otherOrder, CourierID: 1,
+			Strategy: pickup.FIFOStrategy}, mocks.Next(t, pubCh))
+
+		// The second courier now comes for the first order.
+		ch <- &common.ShelvedEvent{Dt: clock.Now(), Order: testOrder, Shelf: "a shelf"}
+		clock.BlockUntil(2)
+		clock.Advance(tripTime / 2)
+		assert.Equal(t, &common.CourierArrivedEvent{Dt: clock.Now(), CourierID: 2, OrderID: testOrder.ID},
+			mocks.Next(t, pubCh))
+		assert.Equal(t, &common.PickupEvent{Dt: clock.Now(), Order: testOrder, CourierID: 2,
+			Strategy: pickup.FIFOStrategy}, mocks.Next(t, pubCh))
+	})
+	t.Run("Stopping the service cancels the trips under way", func(t *testing.T) {
+		ps, clock, ch := &mocks.MockPubsub{}, common.NewSimClock(time.Now()), make(chan interface{})
+		pubCh := ps.Record()
+		ctx, stop := context.WithCancel(context.Background())
+		done := make(chan bool)
+		go func() {
+			pickup.Run0(ctx, mocks.MockRand{MockResult: tripTime.Seconds()}, pickup.PickupMatched{}, ps, clock, 1, ch)
+			done <- true
+		}()
+
+		ch <- &common.NewOrderEvent{Dt: clock.Now(), Order: testOrder}
+		mocks.Next(t, pubCh)
+		clock.BlockUntil(1)
+		stop()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("the service did not stop")
+		}
+		clock.Advance(tripTime)
+		mocks.None(t, pubCh)
+	})
 }
 
-func initParams() (ps *mocks.MockPubsub,
-	shelvedCh chan interface{}, expiredCh chan interface{}, userRequestCh chan interface{}, stopCh chan bool) {
-	ps = &mocks.MockPubsub{}
-	shelvedCh = make(chan interface{})
-	expiredCh = make(chan interface{})
-	userRequestCh = make(chan interface{})
-	stopCh = make(chan bool)
+// Start the service with a fleet of the given size, following strategy.  Events published by the service are passed
+// on pubCh.
+func start(couriers int, strategy pickup.PickupStrategy) (clock *common.SimClock, ch chan interface{},
+	pubCh chan interface{}, stop context.CancelFunc) {
+	ps := &mocks.MockPubsub{}
+	pubCh = ps.Record()
+	clock, ch = common.NewSimClock(time.Now()), make(chan interface{})
+	var ctx context.Context
+	ctx, stop = context.WithCancel(context.Background())
+	go pickup.Run0(ctx, mocks.MockRand{MockResult: tripTime.Seconds()}, strategy, ps, clock, couriers, ch)
 	return
 }
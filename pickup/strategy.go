@@ -0,0 +1,89 @@
+package pickup
+
+import (
+	"stream-first/common"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// ReadyOrder is an order on a shelf, waiting to be picked up.
+type ReadyOrder struct {
+	Order     common.Order
+	ShelvedAt time.Time
+	// Latest normalized value published for the order.
+	NormValue float32
+}
+
+// PickupStrategy decides which ready order a courier at the kitchen picks up.
+type PickupStrategy interface {
+	// Pick chooses one of the ready orders, which are in the order they were shelved.  dispatchedFor is the ID of the
+	// order the courier was dispatched for.  It returns nil to have the courier wait.
+	Pick(dispatchedFor uuid.UUID, ready []*ReadyOrder) *ReadyOrder
+	// Name returns the name the strategy is known by, see PickupStrategies.
+	Name() string
+}
+
+// Pickup strategy names
+const (
+	MatchedStrategy     = "matched"
+	FIFOStrategy        = "fifo"
+	LowestValueStrategy = "lowest-value"
+)
+
+// PickupStrategies lists the pickup strategy names.
+var PickupStrategies = []string{MatchedStrategy, FIFOStrategy, LowestValueStrategy}
+
+// NewPickupStrategy returns the named strategy.
+func NewPickupStrategy(name string) (PickupStrategy, error) {
+	switch name {
+	case MatchedStrategy:
+		return PickupMatched{}, nil
+	case FIFOStrategy:
+		return PickupFIFO{}, nil
+	case LowestValueStrategy:
+		return PickupLowestValue{}, nil
+	}
+	return nil, errors.Errorf("unknown pickup strategy %q, expected one of %v", name, PickupStrategies)
+}
+
+// PickupMatched picks up only the order the courier was dispatched for.
+type PickupMatched struct{}
+
+func (PickupMatched) Name() string { return MatchedStrategy }
+
+func (PickupMatched) Pick(dispatchedFor uuid.UUID, ready []*ReadyOrder) *ReadyOrder {
+	for _, r := range ready {
+		if r.Order.ID == dispatchedFor {
+			return r
+		}
+	}
+	return nil
+}
+
+// PickupFIFO picks up the order that has been on the shelves the longest.
+type PickupFIFO struct{}
+
+func (PickupFIFO) Name() string { return FIFOStrategy }
+
+func (PickupFIFO) Pick(_ uuid.UUID, ready []*ReadyOrder) *ReadyOrder {
+	if len(ready) == 0 {
+		return nil
+	}
+	return ready[0]
+}
+
+// PickupLowestValue picks up the order with the least value left.
+type PickupLowestValue struct{}
+
+func (PickupLowestValue) Name() string { return LowestValueStrategy }
+
+func (PickupLowestValue) Pick(_ uuid.UUID, ready []*ReadyOrder) (pick *ReadyOrder) {
+	for _, r := range ready {
+		if pick == nil || r.NormValue < pick.NormValue {
+			pick = r
+		}
+	}
+	return
+}
@@ -0,0 +1,43 @@
+package pickup_test
+
+import (
+	"stream-first/common"
+	"stream-first/pickup"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPickupStrategies(t *testing.T) {
+	now := time.Now()
+	first := &pickup.ReadyOrder{Order: common.Order{ID: uuid.New()}, ShelvedAt: now, NormValue: 0.8}
+	lowest := &pickup.ReadyOrder{Order: common.Order{ID: uuid.New()}, ShelvedAt: now.Add(time.Second), NormValue: 0.2}
+	last := &pickup.ReadyOrder{Order: common.Order{ID: uuid.New()}, ShelvedAt: now.Add(2 * time.Second), NormValue: 0.9}
+	ready := []*pickup.ReadyOrder{first, lowest, last}
+
+	for _, tt := range []struct {
+		name          string
+		dispatchedFor uuid.UUID
+		want          *pickup.ReadyOrder
+	}{
+		{pickup.MatchedStrategy, last.Order.ID, last},
+		{pickup.FIFOStrategy, last.Order.ID, first},
+		{pickup.LowestValueStrategy, last.Order.ID, lowest},
+	} {
+		strategy, err := pickup.NewPickupStrategy(tt.name)
+		require.NoError(t, err)
+		assert.Equal(t, tt.name, strategy.Name())
+		assert.Same(t, tt.want, strategy.Pick(tt.dispatchedFor, ready), tt.name)
+		assert.Nil(t, strategy.Pick(tt.dispatchedFor, nil), tt.name)
+	}
+	t.Run("The matched strategy waits for the order the courier was dispatched for", func(t *testing.T) {
+		assert.Nil(t, pickup.PickupMatched{}.Pick(uuid.New(), ready))
+	})
+	t.Run("Unknown strategies are rejected", func(t *testing.T) {
+		_, err := pickup.NewPickupStrategy("nearest")
+		assert.EqualError(t, err, `unknown pickup strategy "nearest", expected one of [matched fifo lowest-value]`)
+	})
+}
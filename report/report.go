@@ -0,0 +1,275 @@
+package report
+
+// The report service keeps running totals of what happened to the orders, for the summary printed at the end of a
+// run.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"stream-first/common"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	serviceName = "Report"
+)
+
+// Summary holds the end of run totals.
+type Summary struct {
+	OrdersReceived int `json:"ordersReceived"`
+	Delivered      int `json:"delivered"`
+	Expired        int `json:"expired"`
+	// Orders thrown away because both their primary shelf and the overflow shelf were full.
+	DiscardedShelvesFull int `json:"discardedShelvesFull"`
+	// Orders thrown away to make room for new orders.
+	Evicted int `json:"evicted"`
+	// Wasted orders, by waste reason.
+	Waste map[string]int `json:"waste"`
+	// Average normalized value of the delivered orders at pickup time, by temp.
+	AvgNormValueAtPickup map[string]float32 `json:"avgNormValueAtPickup"`
+	// Number of orders placed on the overflow shelf when shelved.
+	OverflowShelved int `json:"overflowShelved"`
+	// Largest number of orders on the overflow shelf at any one time.
+	PeakOverflowOccupancy int `json:"peakOverflowOccupancy"`
+	Reshelved             int `json:"reshelved"`
+	// Pickup waits by the pickup strategy the couriers followed.  Replayed pickups have no couriers, and no waits.
+	PickupWaits map[string]PickupWaits `json:"pickupWaits"`
+}
+
+// PickupWaits holds the average waits of the pickups made following one pickup strategy.
+type PickupWaits struct {
+	Pickups int `json:"pickups"`
+	// Average seconds couriers waited at the kitchen for an order, and orders waited on a shelf for a courier.
+	AvgCourierWaitSeconds float64 `json:"avgCourierWaitSeconds"`
+	AvgOrderWaitSeconds   float64 `json:"avgOrderWaitSeconds"`
+}
+
+// Total waits of the pickups made following one strategy.
+type waitTotals struct {
+	courierWaitSum, orderWaitSum float64
+	pickups                      int
+}
+
+// Report accumulates the summary from the event stream.  It is safe to read the summary while the service runs.
+type Report struct {
+	mu      sync.Mutex
+	summary Summary
+	// Latest normalized value published for each shelved order.
+	normValues map[uuid.UUID]float32
+	// Orders currently on the overflow shelf.
+	onOverflow map[uuid.UUID]bool
+	// Sum and count of normalized values at pickup, by temp.
+	pickupNormValueSums map[string]float64
+	pickupCounts        map[string]int
+	waste               map[string]int
+	// When each shelved order was first shelved, and when each courier at the kitchen arrived.
+	shelvedAt map[uuid.UUID]time.Time
+	arrivedAt map[int]time.Time
+	// Total waits of the pickups made by couriers, by pickup strategy.
+	waits map[string]*waitTotals
+}
+
+// New returns an empty report.
+func New() *Report {
+	return &Report{
+		normValues:          map[uuid.UUID]float32{},
+		onOverflow:          map[uuid.UUID]bool{},
+		pickupNormValueSums: map[string]float64{},
+		pickupCounts:        map[string]int{},
+		waste:               map[string]int{},
+		shelvedAt:           map[uuid.UUID]time.Time{},
+		arrivedAt:           map[int]time.Time{},
+		waits:               map[string]*waitTotals{},
+	}
+}
+
+// Run keeps the totals until ctx is done.
+func (r *Report) Run(ctx context.Context, ps common.PubsubInterface) {
+	// A single subscription keeps events in publishing order.
+	ch := ps.Sub(common.NewOrderTopic, common.ShelvedTopic, common.ReshelvedTopic, common.PickupTopic,
+		common.ExpiredTopic, common.WasteTopic, common.ValueTopic, common.CourierArrivedTopic)
+	r.Run0(ctx, ps, ch)
+}
+
+// Run0 is a testable version of the service.  It allows injecting the event channel.  It returns once ch is closed,
+// or once ctx is done and the events already received are counted.
+func (r *Report) Run0(ctx context.Context, ps common.PubsubInterface, ch chan interface{}) {
+	apply := func(msg interface{}) { r.apply(ps, msg) }
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			apply(msg)
+		case <-ctx.Done():
+			common.Drain(ch, apply)
+			return
+		}
+	}
+}
+
+// Add an event to the totals.
+func (r *Report) apply(ps common.PubsubInterface, msg interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch e := msg.(type) {
+	case *common.NewOrderEvent:
+		r.summary.OrdersReceived++
+	case *common.ShelvedEvent:
+		if _, ok := r.shelvedAt[e.Order.ID]; !ok {
+			r.shelvedAt[e.Order.ID] = e.Dt
+		}
+		if e.Shelf == common.OverflowShelfName {
+			r.summary.OverflowShelved++
+			r.addToOverflow(e.Order.ID)
+		}
+	case *common.ReshelvedEvent:
+		r.summary.Reshelved++
+		if e.Shelf == common.OverflowShelfName {
+			r.addToOverflow(e.OrderID)
+		} else {
+			delete(r.onOverflow, e.OrderID)
+		}
+	case *common.PickupEvent:
+		r.summary.Delivered++
+		if normValue, ok := r.normValues[e.Order.ID]; ok {
+			r.pickupNormValueSums[e.Order.Temp] += float64(normValue)
+			r.pickupCounts[e.Order.Temp]++
+		}
+		arrivedAt, arrived := r.arrivedAt[e.CourierID]
+		shelvedAt, shelved := r.shelvedAt[e.Order.ID]
+		if arrived && shelved {
+			totals := r.waits[e.Strategy]
+			if totals == nil {
+				totals = &waitTotals{}
+				r.waits[e.Strategy] = totals
+			}
+			totals.courierWaitSum += e.Dt.Sub(arrivedAt).Seconds()
+			totals.orderWaitSum += e.Dt.Sub(shelvedAt).Seconds()
+			totals.pickups++
+		}
+		delete(r.arrivedAt, e.CourierID)
+		r.forget(e.Order.ID)
+	case *common.ExpiredEvent:
+		r.summary.Expired++
+		r.forget(e.Order.ID)
+	case *common.CourierArrivedEvent:
+		r.arrivedAt[e.CourierID] = e.Dt
+	case *common.ValueEvent:
+		r.normValues[e.Order.ID] = e.NormValue
+	case *common.WasteEvent:
+		r.waste[e.Reason]++
+		switch e.Reason {
+		case common.WasteShelvesFull:
+			r.summary.DiscardedShelvesFull++
+		case common.WasteEvicted:
+			r.summary.Evicted++
+			r.forget(e.Order.ID)
+		}
+	default:
+		common.Diag(ps, serviceName, common.Error, common.CoerceErrorMessage(msg, e), nil)
+	}
+}
+
+// Called with the lock held.
+func (r *Report) addToOverflow(orderID uuid.UUID) {
+	r.onOverflow[orderID] = true
+	if len(r.onOverflow) > r.summary.PeakOverflowOccupancy {
+		r.summary.PeakOverflowOccupancy = len(r.onOverflow)
+	}
+}
+
+// Called with the lock held.
+func (r *Report) forget(orderID uuid.UUID) {
+	delete(r.normValues, orderID)
+	delete(r.onOverflow, orderID)
+	delete(r.shelvedAt, orderID)
+}
+
+// Summary returns the totals so far.
+func (r *Report) Summary() Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.summary
+	s.AvgNormValueAtPickup = map[string]float32{}
+	for temp, count := range r.pickupCounts {
+		s.AvgNormValueAtPickup[temp] = float32(r.pickupNormValueSums[temp] / float64(count))
+	}
+	s.PickupWaits = map[string]PickupWaits{}
+	for strategy, totals := range r.waits {
+		s.PickupWaits[strategy] = PickupWaits{
+			Pickups:               totals.pickups,
+			AvgCourierWaitSeconds: totals.courierWaitSum / float64(totals.pickups),
+			AvgOrderWaitSeconds:   totals.orderWaitSum / float64(totals.pickups),
+		}
+	}
+	s.Waste = map[string]int{}
+	for reason, count := range r.waste {
+		s.Waste[reason] = count
+	}
+	return s
+}
+
+// String formats the summary for people.
+func (s Summary) String() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "Orders received:              %6d\n", s.OrdersReceived)
+	_, _ = fmt.Fprintf(&b, "Delivered:                    %6d\n", s.Delivered)
+	_, _ = fmt.Fprintf(&b, "Expired:                      %6d\n", s.Expired)
+	_, _ = fmt.Fprintf(&b, "Discarded, shelves full:      %6d\n", s.DiscardedShelvesFull)
+	_, _ = fmt.Fprintf(&b, "Evicted:                      %6d\n", s.Evicted)
+	_, _ = fmt.Fprintf(&b, "Placed on overflow:           %6d\n", s.OverflowShelved)
+	_, _ = fmt.Fprintf(&b, "Peak overflow occupancy:      %6d\n", s.PeakOverflowOccupancy)
+	_, _ = fmt.Fprintf(&b, "Reshelved:                    %6d\n", s.Reshelved)
+	_, _ = fmt.Fprintf(&b, "Waste by reason:\n")
+	for _, reason := range sortedKeys(s.Waste) {
+		_, _ = fmt.Fprintf(&b, "  %-20v        %6d\n", reason, s.Waste[reason])
+	}
+	_, _ = fmt.Fprintf(&b, "Average normalized value at pickup:\n")
+	temps := make([]string, 0, len(s.AvgNormValueAtPickup))
+	for temp := range s.AvgNormValueAtPickup {
+		temps = append(temps, temp)
+	}
+	sort.Strings(temps)
+	for _, temp := range temps {
+		_, _ = fmt.Fprintf(&b, "  %-10v                  %6.3f\n", temp, s.AvgNormValueAtPickup[temp])
+	}
+	strategies := make([]string, 0, len(s.PickupWaits))
+	for strategy := range s.PickupWaits {
+		strategies = append(strategies, strategy)
+	}
+	sort.Strings(strategies)
+	for _, strategy := range strategies {
+		waits := s.PickupWaits[strategy]
+		if strategy == "" {
+			strategy = "unknown"
+		}
+		_, _ = fmt.Fprintf(&b, "Average pickup wait, %v strategy, %v pickups:\n", strategy, waits.Pickups)
+		_, _ = fmt.Fprintf(&b, "  courier, seconds            %6.3f\n", waits.AvgCourierWaitSeconds)
+		_, _ = fmt.Fprintf(&b, "  order, seconds              %6.3f\n", waits.AvgOrderWaitSeconds)
+	}
+	return b.String()
+}
+
+func sortedKeys(m map[string]int) (keys []string) {
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// WriteJSON writes the summary in json format.
+func (s Summary) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
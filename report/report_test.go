@@ -0,0 +1,96 @@
+package report_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"stream-first/common"
+	"stream-first/mocks"
+	"stream-first/report"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newOrder(temp string) common.Order {
+	return common.Order{ID: uuid.New(), Name: "an order", Temp: temp, ShelfLife: 100, DecayRate: 1}
+}
+
+func TestRun0(t *testing.T) {
+	ps, ch := &mocks.MockPubsub{}, make(chan interface{})
+	r := report.New()
+	go r.Run0(context.Background(), ps, ch)
+	defer close(ch)
+
+	hot1, hot2, cold, expired, wasted := newOrder("hot"), newOrder("hot"), newOrder("cold"), newOrder("cold"), newOrder("hot")
+	for _, o := range []common.Order{hot1, hot2, cold, expired, wasted} {
+		ch <- &common.NewOrderEvent{Order: o}
+	}
+	start := time.Now()
+	ch <- &common.ShelvedEvent{Dt: start, Order: hot1, Shelf: "hot"}
+	ch <- &common.ShelvedEvent{Dt: start.Add(time.Second), Order: hot2, Shelf: common.OverflowShelfName}
+	ch <- &common.ShelvedEvent{Order: cold, Shelf: common.OverflowShelfName}
+	ch <- &common.ShelvedEvent{Order: expired, Shelf: "cold"}
+	ch <- &common.WasteEvent{Order: wasted, Reason: common.WasteShelvesFull}
+	ch <- &common.ReshelvedEvent{OrderID: hot1.ID, Shelf: common.OverflowShelfName}
+	ch <- &common.ReshelvedEvent{OrderID: cold.ID, Shelf: "cold"}
+	ch <- &common.ValueEvent{Order: hot1, NormValue: 0.8}
+	ch <- &common.ValueEvent{Order: hot2, NormValue: 0.4}
+	ch <- &common.ValueEvent{Order: cold, NormValue: 0.5}
+	ch <- &common.CourierArrivedEvent{Dt: start.Add(2 * time.Second), CourierID: 1, OrderID: hot2.ID}
+	ch <- &common.CourierArrivedEvent{Dt: start.Add(2 * time.Second), CourierID: 2, OrderID: hot1.ID}
+	ch <- &common.PickupEvent{Dt: start.Add(3 * time.Second), Order: hot1, CourierID: 1, Strategy: "fifo"}
+	ch <- &common.PickupEvent{Dt: start.Add(5 * time.Second), Order: hot2, CourierID: 2, Strategy: "lowest-value"}
+	ch <- &common.PickupEvent{Order: cold}
+	ch <- &common.ExpiredEvent{Order: expired}
+	ch <- &common.WasteEvent{Order: expired, Shelf: "cold", Reason: common.WasteExpiredOnPrimary}
+	time.Sleep(common.Seconds(common.SchedulerDelay))
+
+	want := report.Summary{
+		OrdersReceived:        5,
+		Delivered:             3,
+		Expired:               1,
+		DiscardedShelvesFull:  1,
+		Waste:                 map[string]int{common.WasteShelvesFull: 1, common.WasteExpiredOnPrimary: 1},
+		AvgNormValueAtPickup:  map[string]float32{"hot": 0.6, "cold": 0.5},
+		OverflowShelved:       2,
+		PeakOverflowOccupancy: 3,
+		Reshelved:             2,
+		PickupWaits: map[string]report.PickupWaits{
+			"fifo":         {Pickups: 1, AvgCourierWaitSeconds: 1, AvgOrderWaitSeconds: 3},
+			"lowest-value": {Pickups: 1, AvgCourierWaitSeconds: 3, AvgOrderWaitSeconds: 4},
+		},
+	}
+	got := r.Summary()
+	require.Equal(t, want, got)
+
+	t.Run("The summary can be written as json", func(t *testing.T) {
+		var b bytes.Buffer
+		require.NoError(t, got.WriteJSON(&b))
+		var decoded report.Summary
+		require.NoError(t, json.Unmarshal(b.Bytes(), &decoded))
+		assert.Equal(t, want, decoded)
+	})
+	t.Run("The summary can be formatted for people", func(t *testing.T) {
+		assert.Contains(t, got.String(), "Orders received:                   5\n")
+		assert.Contains(t, got.String(), "  hot                          0.600\n")
+		assert.Contains(t, got.String(),
+			"Average pickup wait, fifo strategy, 1 pickups:\n  courier, seconds             1.000\n")
+		assert.Contains(t, got.String(),
+			"Average pickup wait, lowest-value strategy, 1 pickups:\n  courier, seconds             3.000\n")
+	})
+}
+
+func TestRun0_stop(t *testing.T) {
+	ps, ch := &mocks.MockPubsub{}, make(chan interface{}, 2)
+	r := report.New()
+	ctx, stop := context.WithCancel(context.Background())
+	ch <- &common.NewOrderEvent{Order: newOrder("hot")}
+	ch <- &common.NewOrderEvent{Order: newOrder("cold")}
+	stop()
+	r.Run0(ctx, ps, ch)
+	assert.Equal(t, 2, r.Summary().OrdersReceived)
+}
@@ -0,0 +1,91 @@
+package shelf
+
+import (
+	"sort"
+	"stream-first/shelflife"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/rand"
+)
+
+// EvictionPolicy decides what to throw away when an order arrives while both its primary shelf and the overflow
+// shelf are full.
+type EvictionPolicy interface {
+	// Victim picks one of the candidates to be evicted, making room for the new order.  It returns nil to
+	// discard the new order instead.  Candidates are in a stable order, so that random choices are repeatable.
+	Victim(candidates []*shelflife.OrderState, now time.Time) *shelflife.OrderState
+}
+
+// Eviction policy names
+const (
+	DiscardNewPolicy        = "discard-new"
+	EvictLowestValuePolicy  = "evict-lowest-value"
+	EvictRandomPolicy       = "evict-random"
+	EvictHighestDecayPolicy = "evict-highest-decay"
+)
+
+// EvictionPolicies lists the eviction policy names.
+var EvictionPolicies = []string{DiscardNewPolicy, EvictLowestValuePolicy, EvictRandomPolicy, EvictHighestDecayPolicy}
+
+// NewEvictionPolicy returns the named policy.  src is only used by the random policy.
+func NewEvictionPolicy(name string, src rand.Source) (EvictionPolicy, error) {
+	switch name {
+	case DiscardNewPolicy:
+		return DiscardNew{}, nil
+	case EvictLowestValuePolicy:
+		return EvictLowestValue{}, nil
+	case EvictRandomPolicy:
+		return EvictRandom{Rand: rand.New(src)}, nil
+	case EvictHighestDecayPolicy:
+		return EvictHighestDecay{}, nil
+	}
+	return nil, errors.Errorf("unknown eviction policy %q, expected one of %v", name, EvictionPolicies)
+}
+
+// DiscardNew never evicts, the new order is wasted.
+type DiscardNew struct{}
+
+func (DiscardNew) Victim([]*shelflife.OrderState, time.Time) *shelflife.OrderState {
+	return nil
+}
+
+// EvictLowestValue evicts the order with the least value left.
+type EvictLowestValue struct{}
+
+func (EvictLowestValue) Victim(candidates []*shelflife.OrderState, now time.Time) *shelflife.OrderState {
+	return pickMax(candidates, now, func(_ *shelflife.OrderState, value float32) float64 {
+		return -float64(value)
+	})
+}
+
+// EvictRandom evicts an order picked at random.
+type EvictRandom struct {
+	Rand *rand.Rand
+}
+
+func (p EvictRandom) Victim(candidates []*shelflife.OrderState, _ time.Time) *shelflife.OrderState {
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[p.Rand.Intn(len(candidates))]
+}
+
+// EvictHighestDecay evicts the order that loses value the fastest.
+type EvictHighestDecay struct{}
+
+func (EvictHighestDecay) Victim(candidates []*shelflife.OrderState, _ time.Time) (victim *shelflife.OrderState) {
+	for _, candidate := range candidates {
+		if victim == nil || candidate.Order.DecayRate > victim.Order.DecayRate {
+			victim = candidate
+		}
+	}
+	return
+}
+
+// Sort order states by order ID, to make map iteration order repeatable.
+func sortStates(states []*shelflife.OrderState) {
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Order.ID.String() < states[j].Order.ID.String()
+	})
+}
@@ -0,0 +1,55 @@
+package shelf_test
+
+import (
+	"stream-first/common"
+	"stream-first/shelf"
+	"stream-first/shelflife"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEvictionPolicy(t *testing.T) {
+	for _, name := range shelf.EvictionPolicies {
+		policy, err := shelf.NewEvictionPolicy(name, common.NewSource(1, "test"))
+		require.NoError(t, err, name)
+		assert.NotNil(t, policy, name)
+	}
+	_, err := shelf.NewEvictionPolicy("evict-everything", common.NewSource(1, "test"))
+	assert.Error(t, err)
+}
+
+func TestEvictionPolicy_Victim(t *testing.T) {
+	now := time.Now()
+	candidates := []*shelflife.OrderState{
+		// Value 100 - 10 - 0.5 * 10 = 85
+		shelflife.NewOrderState(&common.Order{ID: orderIDs[0], ShelfLife: 100, DecayRate: 0.5}, "hot", 1,
+			now.Add(-10*time.Second)),
+		// Value 50 - 10 - 0.2 * 2 * 10 = 36
+		shelflife.NewOrderState(&common.Order{ID: orderIDs[1], ShelfLife: 50, DecayRate: 0.2},
+			common.OverflowShelfName, 2, now.Add(-10*time.Second)),
+		// Value 200 - 20 - 0.8 * 20 = 164
+		shelflife.NewOrderState(&common.Order{ID: orderIDs[2], ShelfLife: 200, DecayRate: 0.8}, "hot", 1,
+			now.Add(-20*time.Second)),
+	}
+	assert.Nil(t, shelf.DiscardNew{}.Victim(candidates, now))
+	assert.Equal(t, orderIDs[1], shelf.EvictLowestValue{}.Victim(candidates, now).Order.ID)
+	assert.Equal(t, orderIDs[2], shelf.EvictHighestDecay{}.Victim(candidates, now).Order.ID)
+	assert.Nil(t, shelf.EvictHighestDecay{}.Victim(nil, now))
+
+	t.Run("Random victims are repeatable for a given seed", func(t *testing.T) {
+		newPolicy := func() shelf.EvictionPolicy {
+			policy, err := shelf.NewEvictionPolicy(shelf.EvictRandomPolicy, common.NewSource(42, "test"))
+			require.NoError(t, err)
+			return policy
+		}
+		p1, p2 := newPolicy(), newPolicy()
+		for i := 0; i < 10; i++ {
+			v1 := p1.Victim(candidates, now)
+			require.NotNil(t, v1)
+			assert.Equal(t, v1, p2.Victim(candidates, now))
+		}
+	})
+}
@@ -0,0 +1,11 @@
+package shelf
+
+import "github.com/google/uuid"
+
+// MisplaceState makes the manager's state of an order refer to another order, so that tests can make moving the
+// order fail.
+func (w *Manager) MisplaceState(orderID uuid.UUID, otherID uuid.UUID) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.states[orderID].Order.ID = otherID
+}
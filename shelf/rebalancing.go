@@ -0,0 +1,93 @@
+package shelf
+
+import (
+	"sort"
+	"stream-first/common"
+	"stream-first/shelflife"
+	"time"
+)
+
+// Swaps must gain at least this much value, so that rounding errors don't make orders go back and forth.
+const minRebalanceGain = 1e-3
+
+// Rebalance moves orders between the primary shelves and the overflow shelf when that increases the total value the
+// orders are expected to have horizon from now, which is the expected time to pickup.  Overflow orders first fill any
+// free primary space, then primary orders are swapped with overflow orders of the same temp, best swap first, for as
+// long as that adds value.  It returns the number of swaps.
+func (w *Manager) Rebalance(now time.Time, horizon time.Duration) (swaps int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	temps := make([]string, 0, len(w.shelves))
+	for temp := range w.shelves {
+		temps = append(temps, temp)
+	}
+	sort.Strings(temps)
+
+	for _, temp := range temps {
+		primaryShelf := w.shelves[temp]
+		for found := true; found && len(primaryShelf.orders) < primaryShelf.capacity; {
+			if found, err = w.reshelf(temp, now); err != nil {
+				return
+			}
+		}
+		for {
+			onPrimary, onOverflow := w.primaryStates(temp), w.overflowStates(temp)
+			var bestGain float64
+			var toOverflow, toPrimary *shelflife.OrderState
+			for _, p := range onPrimary {
+				for _, o := range onOverflow {
+					gain := w.expectedValue(o, now, horizon, temp) +
+						w.expectedValue(p, now, horizon, common.OverflowShelfName) -
+						w.expectedValue(o, now, horizon, common.OverflowShelfName) - w.expectedValue(p, now, horizon, temp)
+					if gain > bestGain {
+						bestGain, toOverflow, toPrimary = gain, p, o
+					}
+				}
+			}
+			if bestGain < minRebalanceGain {
+				break
+			}
+			if err = w.swap(temp, toOverflow, toPrimary, now); err != nil {
+				return
+			}
+			swaps++
+		}
+	}
+	return
+}
+
+// Move one order from the temp's primary shelf to overflow, and another from overflow to the primary shelf.
+func (w *Manager) swap(temp string, toOverflow *shelflife.OrderState, toPrimary *shelflife.OrderState,
+	dt time.Time) (err error) {
+	// Take the order off overflow first, so that a failure leaves both shelves as they were.
+	if _, err = w.overflow.Remove(toPrimary.Order.ID, temp); err != nil {
+		return
+	}
+	primaryShelf := w.shelves[temp]
+	primaryShelf.Remove(toOverflow.Order.ID)
+	primaryShelf.Store(toPrimary.Order.ID)
+	if _, err = w.overflow.Store(toOverflow.Order.ID, temp, toOverflow.Order.DecayRate); err != nil {
+		return
+	}
+
+	// Report the move off overflow first, so that subscribers never see the overflow shelf above capacity.
+	toPrimary.Place(temp, w.layout.DecayModifier(temp), dt)
+	w.ps.Pub(&common.ReshelvedEvent{Dt: dt, OrderID: toPrimary.Order.ID, Shelf: temp}, common.ReshelvedTopic)
+	toOverflow.Place(common.OverflowShelfName, w.layout.DecayModifier(common.OverflowShelfName), dt)
+	w.ps.Pub(&common.ReshelvedEvent{Dt: dt, OrderID: toOverflow.Order.ID, Shelf: common.OverflowShelfName},
+		common.ReshelvedTopic)
+	return
+}
+
+// The value the order would have horizon from now if it spent that time on the given shelf.
+func (w *Manager) expectedValue(state *shelflife.OrderState, now time.Time, horizon time.Duration,
+	shelf string) float64 {
+	projected := *state
+	projected.Placements = append(state.Placements[:len(state.Placements):len(state.Placements)],
+		shelflife.Placement{Shelf: shelf, DecayModifier: w.layout.DecayModifier(shelf), Dt: now})
+	value, err := projected.Value(now.Add(horizon))
+	if err != nil {
+		return 0
+	}
+	return float64(value)
+}
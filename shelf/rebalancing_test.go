@@ -0,0 +1,101 @@
+package shelf_test
+
+import (
+	"github.com/google/uuid"
+	"stream-first/common"
+	"stream-first/shelf"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManager_Rebalance(t *testing.T) {
+	t.Run("Rebalance swaps a slow decaying primary order with a fast decaying overflow order", func(t *testing.T) {
+		ps := newMockPubSub(map[string]bool{common.ReshelvedTopic: true})
+		ps.On("Pub", mock.Anything, mock.Anything)
+		m := shelf.NewManager(ps, testLayout(1, 2), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
+		now := time.Now()
+		slow := common.Order{ID: orderIDs[0], Temp: "hot", ShelfLife: 100, DecayRate: 0.1}
+		fast := common.Order{ID: orderIDs[1], Temp: "hot", ShelfLife: 100, DecayRate: 0.9}
+		_, _ = m.Store(slow, "hot", now)
+		_, _ = m.Store(fast, "hot", now)
+
+		later := now.Add(time.Second)
+		swaps, err := m.Rebalance(later, 5*time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, 1, swaps)
+		shelfName, _, _ := m.Has(slow.ID, "hot")
+		assert.Equal(t, common.OverflowShelfName, shelfName)
+		shelfName, _, _ = m.Has(fast.ID, "hot")
+		assert.Equal(t, "hot", shelfName)
+		ps.AssertCalled(t, "Pub", &common.ReshelvedEvent{Dt: later, OrderID: slow.ID, Shelf: common.OverflowShelfName},
+			[]string{common.ReshelvedTopic})
+		ps.AssertCalled(t, "Pub", &common.ReshelvedEvent{Dt: later, OrderID: fast.ID, Shelf: "hot"},
+			[]string{common.ReshelvedTopic})
+
+		// Swapping back would lose value.
+		swaps, err = m.Rebalance(later.Add(time.Second), 5*time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, 0, swaps)
+		ps.AssertNumberOfCalls(t, "Pub", 2)
+	})
+	t.Run("Rebalance leaves orders in place when moving them would not add value", func(t *testing.T) {
+		ps := newMockPubSub(map[string]bool{common.ReshelvedTopic: true})
+		ps.On("Pub", mock.Anything, mock.Anything)
+		m := shelf.NewManager(ps, testLayout(1, 2), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
+		now := time.Now()
+		_, _ = m.Store(common.Order{ID: orderIDs[0], Temp: "hot", ShelfLife: 100, DecayRate: 0.9}, "hot", now)
+		_, _ = m.Store(common.Order{ID: orderIDs[1], Temp: "hot", ShelfLife: 100, DecayRate: 0.1}, "hot", now)
+		// The cold overflow order decays faster than the hot primary order, but they can't swap.
+		_, _ = m.Store(common.Order{ID: orderIDs[2], Temp: "cold", ShelfLife: 100, DecayRate: 0.99}, "cold", now)
+		_, _ = m.Store(common.Order{ID: orderIDs[3], Temp: "cold", ShelfLife: 100, DecayRate: 0.95}, "cold", now)
+
+		swaps, err := m.Rebalance(now.Add(time.Second), 5*time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, 0, swaps)
+		ps.AssertNotCalled(t, "Pub", mock.Anything, mock.Anything)
+	})
+	t.Run("A swap that cannot take the order off overflow leaves both shelves as they were", func(t *testing.T) {
+		ps := newMockPubSub(map[string]bool{common.ReshelvedTopic: true})
+		ps.On("Pub", mock.Anything, mock.Anything)
+		m := shelf.NewManager(ps, testLayout(1, 2), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
+		now := time.Now()
+		slow := common.Order{ID: orderIDs[0], Temp: "hot", ShelfLife: 100, DecayRate: 0.1}
+		fast := common.Order{ID: orderIDs[1], Temp: "hot", ShelfLife: 100, DecayRate: 0.9}
+		_, _ = m.Store(slow, "hot", now)
+		_, _ = m.Store(fast, "hot", now)
+		// The manager looks the fast order up on overflow by the ID its state refers to.
+		m.MisplaceState(fast.ID, uuid.New())
+		shelves := m.Shelves()
+
+		swaps, err := m.Rebalance(now.Add(time.Second), 5*time.Second)
+		require.Error(t, err)
+		assert.Equal(t, 0, swaps)
+		assert.Equal(t, shelves, m.Shelves())
+		shelfName, _, _ := m.Has(slow.ID, "hot")
+		assert.Equal(t, "hot", shelfName)
+		shelfName, _, _ = m.Has(fast.ID, "hot")
+		assert.Equal(t, common.OverflowShelfName, shelfName)
+		ps.AssertNotCalled(t, "Pub", mock.Anything, mock.Anything)
+	})
+	t.Run("Rebalance follows the decay modifiers of the layout", func(t *testing.T) {
+		ps := newMockPubSub(map[string]bool{common.ReshelvedTopic: true})
+		ps.On("Pub", mock.Anything, mock.Anything)
+		// Orders decay as fast on overflow as on the primary shelf, so swaps gain nothing.
+		layout := testLayout(1, 2)
+		overflowModifier := float32(1)
+		layout.OverflowDecayModifier = &overflowModifier
+		m := shelf.NewManager(ps, layout, shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
+		now := time.Now()
+		_, _ = m.Store(common.Order{ID: orderIDs[0], Temp: "hot", ShelfLife: 100, DecayRate: 0.1}, "hot", now)
+		_, _ = m.Store(common.Order{ID: orderIDs[1], Temp: "hot", ShelfLife: 100, DecayRate: 0.9}, "hot", now)
+
+		swaps, err := m.Rebalance(now.Add(time.Second), 5*time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, 0, swaps)
+		ps.AssertNotCalled(t, "Pub", mock.Anything, mock.Anything)
+	})
+}
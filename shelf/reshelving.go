@@ -0,0 +1,114 @@
+package shelf
+
+import (
+	"stream-first/shelflife"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ReshelfStrategy decides which overflow order moves to a primary shelf when space becomes available on it.
+type ReshelfStrategy interface {
+	// Pick chooses one of the candidates, all of which are overflow orders of the primary shelf's temp.  It returns
+	// nil to leave them all on overflow.  Candidates are in a stable order, so that ties are broken repeatably.
+	// decayModifier is that of the primary shelf.
+	Pick(candidates []*shelflife.OrderState, decayModifier float32, now time.Time) *shelflife.OrderState
+}
+
+// Reshelf strategy names
+const (
+	HighestDecayStrategy    = "highest-decay"
+	LowestValueStrategy     = "lowest-value"
+	SoonestToExpireStrategy = "soonest-to-expire"
+	MaxValueSavedStrategy   = "max-value-saved"
+)
+
+// ReshelfStrategies lists the reshelf strategy names.
+var ReshelfStrategies = []string{HighestDecayStrategy, LowestValueStrategy, SoonestToExpireStrategy,
+	MaxValueSavedStrategy}
+
+// NewReshelfStrategy returns the named strategy.
+func NewReshelfStrategy(name string) (ReshelfStrategy, error) {
+	switch name {
+	case HighestDecayStrategy:
+		return ReshelfHighestDecay{}, nil
+	case LowestValueStrategy:
+		return ReshelfLowestValue{}, nil
+	case SoonestToExpireStrategy:
+		return ReshelfSoonestToExpire{}, nil
+	case MaxValueSavedStrategy:
+		return ReshelfMaxValueSaved{}, nil
+	}
+	return nil, errors.Errorf("unknown reshelf strategy %q, expected one of %v", name, ReshelfStrategies)
+}
+
+// ReshelfHighestDecay moves the order with the highest decay rate.
+type ReshelfHighestDecay struct{}
+
+func (ReshelfHighestDecay) Pick(candidates []*shelflife.OrderState, _ float32,
+	_ time.Time) (pick *shelflife.OrderState) {
+	for _, candidate := range candidates {
+		if pick == nil || candidate.Order.DecayRate > pick.Order.DecayRate {
+			pick = candidate
+		}
+	}
+	return
+}
+
+// ReshelfLowestValue moves the order with the least value left.
+type ReshelfLowestValue struct{}
+
+func (ReshelfLowestValue) Pick(candidates []*shelflife.OrderState, _ float32, now time.Time) *shelflife.OrderState {
+	return pickMax(candidates, now, func(_ *shelflife.OrderState, value float32) float64 {
+		return -float64(value)
+	})
+}
+
+// ReshelfSoonestToExpire moves the order that would expire first if left on overflow.
+type ReshelfSoonestToExpire struct{}
+
+func (ReshelfSoonestToExpire) Pick(candidates []*shelflife.OrderState, _ float32,
+	now time.Time) *shelflife.OrderState {
+	return pickMax(candidates, now, func(candidate *shelflife.OrderState, value float32) float64 {
+		return -overflowSecondsLeft(candidate, value)
+	})
+}
+
+// ReshelfMaxValueSaved moves the order that gains the most by the move: the value it would still have on the
+// primary shelf by the time it would have expired on overflow.
+type ReshelfMaxValueSaved struct{}
+
+func (ReshelfMaxValueSaved) Pick(candidates []*shelflife.OrderState, decayModifier float32,
+	now time.Time) *shelflife.OrderState {
+	return pickMax(candidates, now, func(candidate *shelflife.OrderState, value float32) float64 {
+		// On the primary shelf value is lost at a rate of 1 + decayRate * decayModifier per second, see
+		// shelflife.OrderState.Value.
+		lossRate := 1 + float64(candidate.Order.DecayRate)*float64(decayModifier)
+		return float64(value) - lossRate*overflowSecondsLeft(candidate, value)
+	})
+}
+
+// Seconds until a candidate with the given value expires on the overflow shelf, where it loses 1 + decayRate *
+// decayModifier value per second, with the modifier of its overflow placement.  Orders with other decay models than
+// linear are estimated as linear.
+func overflowSecondsLeft(candidate *shelflife.OrderState, value float32) float64 {
+	placement := candidate.Placements[len(candidate.Placements)-1]
+	return float64(value) / (1 + float64(candidate.Order.DecayRate)*float64(placement.DecayModifier))
+}
+
+// Return the candidate with the highest score.  Candidates whose value cannot be calculated are skipped.
+func pickMax(candidates []*shelflife.OrderState, now time.Time,
+	score func(candidate *shelflife.OrderState, value float32) float64) (pick *shelflife.OrderState) {
+	var maxScore float64
+	for _, candidate := range candidates {
+		value, err := candidate.Value(now)
+		if err != nil {
+			continue
+		}
+		s := score(candidate, value)
+		if pick == nil || s > maxScore {
+			pick, maxScore = candidate, s
+		}
+	}
+	return
+}
@@ -0,0 +1,75 @@
+package shelf_test
+
+import (
+	"stream-first/common"
+	"stream-first/shelf"
+	"stream-first/shelflife"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReshelfStrategy(t *testing.T) {
+	for _, name := range shelf.ReshelfStrategies {
+		strategy, err := shelf.NewReshelfStrategy(name)
+		require.NoError(t, err, name)
+		assert.NotNil(t, strategy, name)
+	}
+	_, err := shelf.NewReshelfStrategy("first-come")
+	assert.Error(t, err)
+}
+
+func TestReshelfStrategy_Pick(t *testing.T) {
+	now := time.Now()
+	onOverflow := func(id int, shelfLife float32, decayRate float32, seconds float64) *shelflife.OrderState {
+		return shelflife.NewOrderState(&common.Order{ID: orderIDs[id], ShelfLife: shelfLife, DecayRate: decayRate},
+			common.OverflowShelfName, 2, now.Add(-common.Seconds(seconds)))
+	}
+	candidates := []*shelflife.OrderState{
+		// Value 80, expires on overflow in 40s, would have 20 left on primary by then.
+		onOverflow(0, 100, 0.5, 10),
+		// Value 38, expires in 31.7s, saves 3.2.
+		onOverflow(1, 50, 0.1, 10),
+		// Value 272, expires in 97.1s, saves 87.4.
+		onOverflow(2, 300, 0.9, 10),
+		// Value 60, expires in 20s, saves 20.
+		onOverflow(3, 60, 1, 0),
+	}
+	tests := []struct {
+		strategy shelf.ReshelfStrategy
+		want     int
+	}{
+		{shelf.ReshelfHighestDecay{}, 3},
+		{shelf.ReshelfLowestValue{}, 1},
+		{shelf.ReshelfSoonestToExpire{}, 3},
+		{shelf.ReshelfMaxValueSaved{}, 2},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, orderIDs[tt.want], tt.strategy.Pick(candidates, 1, now).Order.ID, "%T", tt.strategy)
+		assert.Nil(t, tt.strategy.Pick(nil, 1, now), "%T", tt.strategy)
+	}
+}
+
+func Test_warehouse_reshelving(t *testing.T) {
+	t.Run("Remove reshelves the order picked by the strategy", func(t *testing.T) {
+		ps := newMockPubSub(map[string]bool{common.ReshelvedTopic: true})
+		ps.On("Pub", mock.Anything, mock.Anything)
+		m := shelf.NewManager(ps, testLayout(1, 5), shelf.DiscardNew{}, shelf.ReshelfLowestValue{})
+		now := time.Now()
+		_, _ = m.Store(common.Order{ID: orderIDs[0], ShelfLife: 100, DecayRate: 0.1}, "hot", now)
+		_, _ = m.Store(common.Order{ID: orderIDs[1], ShelfLife: 100, DecayRate: 0.9}, "hot", now)
+		_, _ = m.Store(common.Order{ID: orderIDs[2], ShelfLife: 20, DecayRate: 0.1}, "hot", now)
+		_, _ = m.Store(common.Order{ID: orderIDs[3], ShelfLife: 50, DecayRate: 0.1}, "hot", now)
+
+		found, err := m.Remove(orderIDs[0], "hot", now.Add(5*time.Second))
+		require.NoError(t, err)
+		require.True(t, found)
+		shelfName, _, _ := m.Has(orderIDs[2], "hot")
+		assert.Equal(t, "hot", shelfName)
+		ps.AssertCalled(t, "Pub", &common.ReshelvedEvent{Dt: now.Add(5 * time.Second), OrderID: orderIDs[2], Shelf: "hot"},
+			[]string{common.ReshelvedTopic})
+	})
+}
@@ -1,13 +1,15 @@
 package shelf
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
+	"sync"
 	"time"
 
 	"stream-first/common"
+	"stream-first/shelflife"
 
-	"github.com/cskr/pubsub"
 	"github.com/google/uuid"
 )
 
@@ -151,24 +153,70 @@ func (s *OverflowShelf) PopMax(temp string) (maxOrderID uuid.UUID, found bool, e
 	return
 }
 
+// Manager places orders on the shelves.  It is safe for concurrent use.
 type Manager struct {
-	shelves  map[string]*primaryShelf
-	overflow OverflowShelf
-	ps       common.PubsubInterface
+	mu         sync.Mutex
+	layout     common.ShelfLayout
+	shelves    map[string]*primaryShelf
+	overflow   OverflowShelf
+	ps         common.PubsubInterface
+	eviction   EvictionPolicy
+	reshelving ReshelfStrategy
+	// The state of every stored order, used to pick orders for eviction and reshelving.
+	states map[uuid.UUID]*shelflife.OrderState
 }
 
 // TODO: eliminate use of "warehouse" and w.  use m instead
-func NewManager(ps common.PubsubInterface, primaryCapacity int, overflowCapacity int) *Manager {
-	shelves := map[string]*primaryShelf{
-		"hot":    NewPrimaryShelf(primaryCapacity),
-		"cold":   NewPrimaryShelf(primaryCapacity),
-		"frozen": NewPrimaryShelf(primaryCapacity),
-	}
-	overflow := NewOverflowShelf(overflowCapacity, []string{"hot", "cold", "frozen"})
-	return &Manager{shelves, overflow, ps}
+func NewManager(ps common.PubsubInterface, layout common.ShelfLayout, eviction EvictionPolicy,
+	reshelving ReshelfStrategy) *Manager {
+	shelves := map[string]*primaryShelf{}
+	for _, shelf := range layout.Shelves {
+		shelves[shelf.Temp] = NewPrimaryShelf(shelf.Capacity)
+	}
+	overflow := NewOverflowShelf(layout.OverflowCapacity, layout.Temps())
+	return &Manager{
+		layout:     layout,
+		shelves:    shelves,
+		overflow:   overflow,
+		ps:         ps,
+		eviction:   eviction,
+		reshelving: reshelving,
+		states:     map[uuid.UUID]*shelflife.OrderState{},
+	}
+}
+
+// Layout returns the shelf layout the manager was created with.
+func (w *Manager) Layout() common.ShelfLayout {
+	return w.layout
+}
+
+// ShelfStatus describes the occupancy of a shelf.
+type ShelfStatus struct {
+	Name      string `json:"name"`
+	Capacity  int    `json:"capacity"`
+	Occupancy int    `json:"occupancy"`
+}
+
+// Shelves returns the status of the primary shelves in layout order, followed by the overflow shelf.
+func (w *Manager) Shelves() (statuses []ShelfStatus) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, shelf := range w.layout.Shelves {
+		statuses = append(statuses, ShelfStatus{
+			Name: shelf.Temp, Capacity: shelf.Capacity, Occupancy: len(w.shelves[shelf.Temp].orders)})
+	}
+	statuses = append(statuses, ShelfStatus{
+		Name: common.OverflowShelfName, Capacity: w.overflow.Capacity, Occupancy: w.overflow.NumOrders()})
+	return
 }
 
 func (w *Manager) Store(order common.Order, temp string, Dt time.Time) (stored bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.store(order, temp, Dt)
+}
+
+func (w *Manager) store(order common.Order, temp string, Dt time.Time) (stored bool, err error) {
 	primaryShelf := w.shelves[temp]
 	if primaryShelf == nil {
 		err = errors.Errorf("Invalid temp: %+v", temp)
@@ -177,6 +225,7 @@ func (w *Manager) Store(order common.Order, temp string, Dt time.Time) (stored b
 	stored = primaryShelf.Store(order.ID)
 	if stored {
 		// Successfully stored on primary shelf.
+		w.states[order.ID] = shelflife.NewOrderState(&order, temp, w.layout.DecayModifier(temp), Dt)
 		shelvedEvent := &common.ShelvedEvent{Dt: Dt, Order: order, Shelf: temp}
 
 		w.ps.Pub(shelvedEvent, common.ShelvedTopic)
@@ -185,15 +234,86 @@ func (w *Manager) Store(order common.Order, temp string, Dt time.Time) (stored b
 	stored, err = w.overflow.Store(order.ID, temp, order.DecayRate)
 	if stored {
 		// Successfully stored on overflow shelf.
-		shelvedEvent := &common.ShelvedEvent{Dt: Dt, Order: order, Shelf: "overflow"}
+		w.states[order.ID] = shelflife.NewOrderState(&order, common.OverflowShelfName,
+			w.layout.DecayModifier(common.OverflowShelfName), Dt)
+		shelvedEvent := &common.ShelvedEvent{Dt: Dt, Order: order, Shelf: common.OverflowShelfName}
 		w.ps.Pub(shelvedEvent, common.ShelvedTopic)
 		return
 	}
-	// Storage failed, return false.
+	if err != nil {
+		return
+	}
+	// Both shelves are full, make room if the eviction policy finds a victim.
+	victim := w.eviction.Victim(w.evictionCandidates(temp), Dt)
+	if victim == nil {
+		// Storage failed, return false.
+		return
+	}
+	if err = w.evict(victim, Dt); err != nil {
+		return
+	}
+	return w.store(order, temp, Dt)
+}
+
+// Orders that may be evicted to make room for a new order of the given temp: those on the temp's primary shelf and
+// on the overflow shelf.
+func (w *Manager) evictionCandidates(temp string) (candidates []*shelflife.OrderState) {
+	for _, state := range w.states {
+		if state.Shelf == temp || state.Shelf == common.OverflowShelfName {
+			candidates = append(candidates, state)
+		}
+	}
+	sortStates(candidates)
+	return
+}
+
+// Remove the order without reshelving, and report it as waste.
+func (w *Manager) evict(victim *shelflife.OrderState, dt time.Time) (err error) {
+	orderID := victim.Order.ID
+	if victim.Shelf == common.OverflowShelfName {
+		for temp, orders := range w.overflow.Orders {
+			if _, ok := orders[orderID]; ok {
+				_, err = w.overflow.Remove(orderID, temp)
+				break
+			}
+		}
+	} else if primaryShelf := w.shelves[victim.Shelf]; primaryShelf == nil || !primaryShelf.Remove(orderID) {
+		err = errors.Errorf("cannot evict order %v/%v: not found", orderID, victim.Shelf)
+	}
+	if err != nil {
+		return
+	}
+	delete(w.states, orderID)
+	w.ps.Pub(&common.WasteEvent{Dt: dt, Order: *victim.Order, Shelf: victim.Shelf, Reason: common.WasteEvicted},
+		common.WasteTopic)
+	return
+}
+
+// The states of the orders on the temp's primary shelf, in a stable order.
+func (w *Manager) primaryStates(temp string) (states []*shelflife.OrderState) {
+	for orderID := range w.shelves[temp].orders {
+		if state, ok := w.states[orderID]; ok {
+			states = append(states, state)
+		}
+	}
+	sortStates(states)
+	return
+}
+
+// The states of the overflow orders of the given temp, in a stable order.
+func (w *Manager) overflowStates(temp string) (states []*shelflife.OrderState) {
+	for orderID := range w.overflow.Orders[temp] {
+		if state, ok := w.states[orderID]; ok {
+			states = append(states, state)
+		}
+	}
+	sortStates(states)
 	return
 }
 
 func (w *Manager) Has(orderID uuid.UUID, temp string) (shelf string, found bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	primaryShelf := w.shelves[temp]
 	if primaryShelf == nil {
 		err = errors.Errorf("Invalid temp: %+v", temp)
@@ -206,12 +326,14 @@ func (w *Manager) Has(orderID uuid.UUID, temp string) (shelf string, found bool,
 	}
 	found, err = w.overflow.Has(orderID, temp)
 	if found {
-		shelf = "overflow"
+		shelf = common.OverflowShelfName
 	}
 	return
 }
 
 func (w *Manager) Remove(orderID uuid.UUID, temp string, dt time.Time) (done bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	primaryShelf := w.shelves[temp]
 	if primaryShelf == nil {
 		err = errors.Errorf("Invalid temp: %+v", temp)
@@ -219,10 +341,14 @@ func (w *Manager) Remove(orderID uuid.UUID, temp string, dt time.Time) (done boo
 	}
 	done = primaryShelf.Remove(orderID)
 	if done {
+		delete(w.states, orderID)
 		_, err = w.reshelf(temp, dt)
 		return
 	}
 	done, err = w.overflow.Remove(orderID, temp)
+	if done {
+		delete(w.states, orderID)
+	}
 	return
 }
 
@@ -232,33 +358,46 @@ func (w *Manager) reshelf(temp string, dt time.Time) (found bool, err error) {
 		err = errors.Errorf("Invalid temp: %+v", temp)
 		return
 	}
-	var orderID uuid.UUID
-	orderID, found, err = w.overflow.PopMax(temp)
-	if err != nil || !found {
-		// Error
+	pick := w.reshelving.Pick(w.overflowStates(temp), w.layout.DecayModifier(temp), dt)
+	if pick == nil {
+		return
+	}
+	orderID := pick.Order.ID
+	if found, err = w.overflow.Remove(orderID, temp); err != nil {
 		return
 	}
 	primaryShelf.Store(orderID)
-	reshelvedEvent := &common.ReshelvedEvent{Dt: dt, OrderID: orderID}
+	pick.Place(temp, w.layout.DecayModifier(temp), dt)
+	reshelvedEvent := &common.ReshelvedEvent{Dt: dt, OrderID: orderID, Shelf: temp}
 	w.ps.Pub(reshelvedEvent, common.ReshelvedTopic)
 	return
 }
 
-func Run(ps *pubsub.PubSub) {
-	newOrderCh := ps.Sub(common.NewOrderTopic)
+// Run stores cooked orders on the shelves with the manager, and removes them when they are picked up or expire, until ctx
+// is done.  Every rebalanceInterval, if positive, orders are swapped between primary and overflow shelves when that
+// increases their expected value at rebalanceHorizon, see Manager.Rebalance.
+func Run(ctx context.Context, ps common.PubsubInterface, clock common.Clock, m *Manager,
+	rebalanceInterval time.Duration, rebalanceHorizon time.Duration) {
+	cookedCh := ps.Sub(common.OrderCookedTopic)
 	pickUpCh := ps.Sub(common.PickupTopic)
 	expiredCh := ps.Sub(common.ExpiredTopic)
 
+	var rebalanceCh <-chan time.Time
+	if rebalanceInterval > 0 {
+		ticker := clock.NewTicker(rebalanceInterval)
+		defer ticker.Stop()
+		rebalanceCh = ticker.C()
+	}
+
 	// Allow time for other components to subscribe before starting to publish.
 	time.Sleep(common.Seconds(common.SchedulerDelay))
 
 	common.Diag(ps, serviceName, common.Info, "Service started.", nil)
 
-	m := NewManager(ps, 15, 20)
 	for {
 		select {
-		case msg := <-newOrderCh:
-			e, ok := msg.(*common.NewOrderEvent)
+		case msg := <-cookedCh:
+			e, ok := msg.(*common.OrderCookedEvent)
 			if !ok {
 				common.Diag(ps, serviceName, common.Error, common.CoerceErrorMessage(msg, e), nil)
 				continue
@@ -268,7 +407,8 @@ func Run(ps *pubsub.PubSub) {
 				common.Diag(ps, serviceName, common.Error, "", err)
 			}
 			if !stored {
-				common.Diag(ps, serviceName, common.Error, fmt.Sprintf("Waste - shelves full: %+v", e.Order), nil)
+				ps.Pub(&common.WasteEvent{Dt: e.Dt, Order: e.Order, Reason: common.WasteShelvesFull}, common.WasteTopic)
+				common.Diag(ps, serviceName, common.Warning, fmt.Sprintf("Waste - shelves full: %+v", e.Order), nil)
 			}
 		case msg := <-pickUpCh:
 			e, ok := msg.(*common.PickupEvent)
@@ -284,6 +424,12 @@ func Run(ps *pubsub.PubSub) {
 				continue
 			}
 			_, _ = m.Remove(e.Order.ID, e.Order.Temp, e.Dt)
+		case now := <-rebalanceCh:
+			if _, err := m.Rebalance(now, rebalanceHorizon); err != nil {
+				common.Diag(ps, serviceName, common.Error, "", err)
+			}
+		case <-ctx.Done():
+			return
 		}
 	}
 }
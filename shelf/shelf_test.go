@@ -226,7 +226,7 @@ func Test_warehouse_store_has(t *testing.T) {
 	t.Run("Store fails for invalid temp, does not publish", func(t *testing.T) {
 		ps := newMockPubSub(map[string]bool{})
 		ps.On("Pub", mock.Anything, mock.Anything)
-		m := shelf.NewManager(ps, 3, 5)
+		m := shelf.NewManager(ps, testLayout(3, 5), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
 		o := common.Order{}
 		_, err := m.Store(o, "blah", time.Time{})
 		assert.Error(t, err)
@@ -235,7 +235,7 @@ func Test_warehouse_store_has(t *testing.T) {
 	t.Run("Store places order on primary shelf if it is not full for order's temp", func(t *testing.T) {
 		ps := newMockPubSub(map[string]bool{})
 		ps.On("Pub", mock.Anything, mock.Anything)
-		m := shelf.NewManager(ps, 4, 5)
+		m := shelf.NewManager(ps, testLayout(4, 5), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
 
 		var wantEvents []common.ShelvedEvent
 		for _, id := range []uuid.UUID{orderIDs[0], orderIDs[1], orderIDs[2], orderIDs[3]} {
@@ -254,7 +254,7 @@ func Test_warehouse_store_has(t *testing.T) {
 	})
 	t.Run("Store places order in overflow when primary shelf is full for order's temp", func(t *testing.T) {
 		ps := pubsub.New(1000)
-		m := shelf.NewManager(ps, 3, 5)
+		m := shelf.NewManager(ps, testLayout(3, 5), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
 
 		// Fill up primary
 		for _, id := range []uuid.UUID{orderIDs[1], orderIDs[2], orderIDs[3]} {
@@ -269,7 +269,7 @@ func Test_warehouse_store_has(t *testing.T) {
 	})
 	t.Run("Store stores in overflow when overflow is nearly full", func(t *testing.T) {
 		ps := pubsub.New(1000)
-		m := shelf.NewManager(ps, 3, 5)
+		m := shelf.NewManager(ps, testLayout(3, 5), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
 		orderIDs := generateOrderIds(8)
 
 		// Fill up primary and nearly all of overflow
@@ -286,7 +286,7 @@ func Test_warehouse_store_has(t *testing.T) {
 	})
 	t.Run("Store returns false and does not Store when overflow is full", func(t *testing.T) {
 		ps := pubsub.New(1000)
-		m := shelf.NewManager(ps, 3, 5)
+		m := shelf.NewManager(ps, testLayout(3, 5), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
 		orderIDs := generateOrderIds(9)
 
 		// Fill up primary and overflow
@@ -302,22 +302,54 @@ func Test_warehouse_store_has(t *testing.T) {
 	})
 }
 
+func Test_warehouse_layout(t *testing.T) {
+	t.Run("Store uses the capacity configured for each temp", func(t *testing.T) {
+		ps := pubsub.New(1000)
+		layout := common.ShelfLayout{
+			Shelves: []common.ShelfDefinition{
+				{Temp: "hot", Capacity: 2},
+				{Temp: "ambient", Capacity: 1},
+			},
+			OverflowCapacity: 5,
+		}
+		m := shelf.NewManager(ps, layout, shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
+		orderIDs := generateOrderIds(4)
+		for _, id := range orderIDs[:3] {
+			_, _ = m.Store(common.Order{ID: id}, "hot", time.Time{})
+		}
+		_, _ = m.Store(common.Order{ID: orderIDs[3]}, "ambient", time.Time{})
+
+		shelfName, _, _ := m.Has(orderIDs[1], "hot")
+		assert.Equal(t, "hot", shelfName)
+		shelfName, _, _ = m.Has(orderIDs[2], "hot")
+		assert.Equal(t, common.OverflowShelfName, shelfName)
+		shelfName, _, _ = m.Has(orderIDs[3], "ambient")
+		assert.Equal(t, "ambient", shelfName)
+	})
+	t.Run("Store fails for a temp missing from the layout", func(t *testing.T) {
+		ps := pubsub.New(1000)
+		m := shelf.NewManager(ps, common.NewUniformShelfLayout([]string{"hot"}, 3, 5), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
+		_, err := m.Store(common.Order{ID: uuid.New()}, "frozen", time.Time{})
+		assert.Error(t, err)
+	})
+}
+
 func Test_warehouse_remove(t *testing.T) {
 	t.Run("Remove returns error for invalid temp", func(t *testing.T) {
 		ps := pubsub.New(1000)
-		m := shelf.NewManager(ps, 3, 5)
+		m := shelf.NewManager(ps, testLayout(3, 5), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
 		_, err := m.Remove(uuid.New(), "blah", time.Time{})
 		assert.Error(t, err)
 	})
 	t.Run("Remove returns error when order not in storage", func(t *testing.T) {
 		ps := pubsub.New(1000)
-		m := shelf.NewManager(ps, 3, 5)
+		m := shelf.NewManager(ps, testLayout(3, 5), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
 		_, err := m.Remove(uuid.New(), "hot", time.Time{})
 		assert.Error(t, err)
 	})
 	t.Run("Remove removes order when it's on the primary shelf", func(t *testing.T) {
 		ps := pubsub.New(1000)
-		m := shelf.NewManager(ps, 3, 5)
+		m := shelf.NewManager(ps, testLayout(3, 5), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
 		_, _ = m.Store(common.Order{ID: orderIDs[0]}, "cold", time.Time{})
 		found, err := m.Remove(orderIDs[0], "cold", time.Time{})
 		require.NoError(t, err)
@@ -328,7 +360,7 @@ func Test_warehouse_remove(t *testing.T) {
 	})
 	t.Run("Remove removes order when it's on the overflow shelf", func(t *testing.T) {
 		ps := pubsub.New(1000)
-		m := shelf.NewManager(ps, 3, 5)
+		m := shelf.NewManager(ps, testLayout(3, 5), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
 		// Fill up primary
 		for i := 0; i < 3; i++ {
 			_, _ = m.Store(common.Order{ID: orderIDs[i]}, "hot", time.Time{})
@@ -343,7 +375,7 @@ func Test_warehouse_remove(t *testing.T) {
 	})
 	t.Run("Remove reshelves order with max decay rate from overflow to primary when it becomes available", func(t *testing.T) {
 		ps := pubsub.New(1000)
-		m := shelf.NewManager(ps, 3, 5)
+		m := shelf.NewManager(ps, testLayout(3, 5), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
 
 		// Fill up the primary hot shelf
 		for i := 0; i < 3; i++ {
@@ -368,6 +400,10 @@ func Test_warehouse_remove(t *testing.T) {
 	})
 }
 
+func testLayout(primaryCapacity int, overflowCapacity int) common.ShelfLayout {
+	return common.NewUniformShelfLayout([]string{"frozen", "cold", "hot"}, primaryCapacity, overflowCapacity)
+}
+
 type mockPubSub struct {
 	mock.Mock
 	topicsToFollow map[string]bool
@@ -386,3 +422,69 @@ func (ps *mockPubSub) Pub(msg interface{}, topics ...string) {
 		ps.Called(msg, topics)
 	}
 }
+
+func Test_warehouse_eviction(t *testing.T) {
+	t.Run("Store evicts the policy's victim and reports it as waste when shelves are full", func(t *testing.T) {
+		ps := pubsub.New(1000)
+		wasteCh := ps.Sub(common.WasteTopic)
+		m := shelf.NewManager(ps, testLayout(2, 1), shelf.EvictHighestDecay{}, shelf.ReshelfHighestDecay{})
+		now := time.Now()
+		orders := []common.Order{
+			{ID: orderIDs[0], Temp: "hot", ShelfLife: 100, DecayRate: 0.1},
+			{ID: orderIDs[1], Temp: "hot", ShelfLife: 100, DecayRate: 0.9},
+			{ID: orderIDs[2], Temp: "hot", ShelfLife: 100, DecayRate: 0.3},
+			{ID: orderIDs[3], Temp: "cold", ShelfLife: 100, DecayRate: 0.95},
+		}
+		for _, o := range orders {
+			stored, err := m.Store(o, o.Temp, now)
+			require.NoError(t, err)
+			require.True(t, stored)
+		}
+		// The primary hot shelf and overflow are full.
+		newOrder := common.Order{ID: orderIDs[4], Temp: "hot", ShelfLife: 100, DecayRate: 0.5}
+		stored, err := m.Store(newOrder, newOrder.Temp, now)
+		require.NoError(t, err)
+		require.True(t, stored)
+
+		// The evicted order is the hot one with the highest decay rate.  The cold order decays faster, but it is on
+		// its primary shelf so it is not a candidate.
+		_, found, err := m.Has(orderIDs[1], "hot")
+		require.NoError(t, err)
+		assert.False(t, found)
+		shelfName, found, err := m.Has(orderIDs[4], "hot")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "hot", shelfName)
+		e := (<-wasteCh).(*common.WasteEvent)
+		assert.Equal(t, orderIDs[1], e.Order.ID)
+		assert.Equal(t, "hot", e.Shelf)
+		assert.Equal(t, common.WasteEvicted, e.Reason)
+	})
+	t.Run("Store discards the new order when the policy picks no victim", func(t *testing.T) {
+		ps := newMockPubSub(map[string]bool{common.WasteTopic: true})
+		m := shelf.NewManager(ps, testLayout(1, 1), shelf.DiscardNew{}, shelf.ReshelfHighestDecay{})
+		for _, id := range orderIDs[:2] {
+			_, _ = m.Store(common.Order{ID: id, Temp: "hot"}, "hot", time.Time{})
+		}
+		stored, err := m.Store(common.Order{ID: orderIDs[2], Temp: "hot"}, "hot", time.Time{})
+		require.NoError(t, err)
+		assert.False(t, stored)
+		ps.AssertNotCalled(t, "Pub", mock.Anything, mock.Anything)
+	})
+	t.Run("Removed orders are no longer eviction candidates", func(t *testing.T) {
+		ps := pubsub.New(1000)
+		wasteCh := ps.Sub(common.WasteTopic)
+		m := shelf.NewManager(ps, testLayout(1, 1), shelf.EvictHighestDecay{}, shelf.ReshelfHighestDecay{})
+		now := time.Now()
+		_, _ = m.Store(common.Order{ID: orderIDs[0], Temp: "hot", ShelfLife: 100, DecayRate: 0.1}, "hot", now)
+		_, _ = m.Store(common.Order{ID: orderIDs[1], Temp: "hot", ShelfLife: 100, DecayRate: 0.9}, "hot", now)
+		_, _ = m.Remove(orderIDs[1], "hot", now)
+		_, _ = m.Store(common.Order{ID: orderIDs[2], Temp: "hot", ShelfLife: 100, DecayRate: 0.2}, "hot", now)
+		stored, err := m.Store(common.Order{ID: orderIDs[3], Temp: "hot", ShelfLife: 100, DecayRate: 0.2}, "hot", now)
+		require.NoError(t, err)
+		require.True(t, stored)
+		e := (<-wasteCh).(*common.WasteEvent)
+		assert.Equal(t, orderIDs[2], e.Order.ID)
+		assert.Equal(t, common.OverflowShelfName, e.Shelf)
+	})
+}
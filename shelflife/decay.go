@@ -0,0 +1,130 @@
+package shelflife
+
+import (
+	"math"
+	"stream-first/common"
+
+	"github.com/pkg/errors"
+)
+
+// DecayModel describes how an order loses value as it ages.
+type DecayModel interface {
+	// Value returns what is left of shelfLife once the order has reached effectiveAge, see common.DecaySpec.
+	Value(shelfLife float64, effectiveAge float64) float64
+}
+
+// Decay model names
+const (
+	LinearDecay      = "linear"
+	ExponentialDecay = "exponential"
+	PiecewiseDecay   = "piecewise"
+	TableDecay       = "table"
+)
+
+// DecayModels lists the decay model names.
+var DecayModels = []string{LinearDecay, ExponentialDecay, PiecewiseDecay, TableDecay}
+
+// NewDecayModel returns the model of spec, or the linear model if spec is nil.
+func NewDecayModel(spec *common.DecaySpec) (DecayModel, error) {
+	if spec == nil {
+		return LinearDecayModel{}, nil
+	}
+	switch spec.Model {
+	case LinearDecay:
+		return LinearDecayModel{}, nil
+	case ExponentialDecay:
+		if spec.Rate <= 0 {
+			return nil, errors.Errorf("exponential decay rate must be positive, got %v", spec.Rate)
+		}
+		return ExponentialDecayModel{Rate: float64(spec.Rate)}, nil
+	case PiecewiseDecay:
+		if err := validatePoints(spec.Points); err != nil {
+			return nil, errors.Wrap(err, "piecewise decay")
+		}
+		return PiecewiseDecayModel{Points: spec.Points}, nil
+	case TableDecay:
+		if err := validatePoints(spec.Points); err != nil {
+			return nil, errors.Wrap(err, "table decay")
+		}
+		return TableDecayModel{Points: spec.Points}, nil
+	}
+	return nil, errors.Errorf("unknown decay model %q, expected one of %v", spec.Model, DecayModels)
+}
+
+// Points start at age 0, and are in increasing age order with values between 0 and 1.
+func validatePoints(points []common.DecayPoint) error {
+	if len(points) == 0 || points[0].Age != 0 {
+		return errors.New("the first point must be at age 0")
+	}
+	for i, point := range points {
+		if i > 0 && point.Age <= points[i-1].Age {
+			return errors.Errorf("point ages must increase, got %v after %v", point.Age, points[i-1].Age)
+		}
+		if point.Value < 0 || point.Value > 1 {
+			return errors.Errorf("point values must be between 0 and 1, got %v", point.Value)
+		}
+	}
+	return nil
+}
+
+// LinearDecayModel loses value at a constant rate, and expires once the effective age reaches the shelf life.
+type LinearDecayModel struct{}
+
+func (LinearDecayModel) Value(shelfLife float64, effectiveAge float64) float64 {
+	return shelfLife - effectiveAge
+}
+
+// ExponentialDecayModel loses value fast at first, and slower as it ages, like fries going soggy.  The curve is
+// scaled so that the order still expires once the effective age reaches the shelf life.
+type ExponentialDecayModel struct {
+	Rate float64
+}
+
+func (m ExponentialDecayModel) Value(shelfLife float64, effectiveAge float64) float64 {
+	if effectiveAge >= shelfLife {
+		return 0
+	}
+	floor := math.Exp(-m.Rate * shelfLife)
+	return shelfLife * (math.Exp(-m.Rate*effectiveAge) - floor) / (1 - floor)
+}
+
+// PiecewiseDecayModel interpolates the normalized value linearly between points.  Past the last point, the value stays
+// at that of the last point, until the order expires once the effective age reaches the shelf life.
+type PiecewiseDecayModel struct {
+	Points []common.DecayPoint
+}
+
+func (m PiecewiseDecayModel) Value(shelfLife float64, effectiveAge float64) float64 {
+	if effectiveAge >= shelfLife {
+		return 0
+	}
+	last := m.Points[len(m.Points)-1]
+	if effectiveAge >= float64(last.Age) {
+		return shelfLife * float64(last.Value)
+	}
+	i := lastPointAt(m.Points, effectiveAge)
+	from, to := m.Points[i], m.Points[i+1]
+	fraction := (effectiveAge - float64(from.Age)) / float64(to.Age-from.Age)
+	return shelfLife * (float64(from.Value) + fraction*float64(to.Value-from.Value))
+}
+
+// TableDecayModel keeps the normalized value of the last point reached, like ice cream that is fine until it melts.
+// The order expires once the effective age reaches the shelf life, whatever the points.
+type TableDecayModel struct {
+	Points []common.DecayPoint
+}
+
+func (m TableDecayModel) Value(shelfLife float64, effectiveAge float64) float64 {
+	if effectiveAge >= shelfLife {
+		return 0
+	}
+	return shelfLife * float64(m.Points[lastPointAt(m.Points, effectiveAge)].Value)
+}
+
+// The index of the last point at or before age.
+func lastPointAt(points []common.DecayPoint, age float64) (i int) {
+	for i+1 < len(points) && float64(points[i+1].Age) <= age {
+		i++
+	}
+	return
+}
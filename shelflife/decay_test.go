@@ -0,0 +1,79 @@
+package shelflife_test
+
+import (
+	"math"
+	"stream-first/common"
+	"stream-first/shelflife"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecayModels(t *testing.T) {
+	points := []common.DecayPoint{{Age: 0, Value: 1}, {Age: 10, Value: 0.8}, {Age: 20, Value: 0}}
+	// Never reaches 0 by the points alone.
+	kept := []common.DecayPoint{{Age: 0, Value: 1}, {Age: 10, Value: 0.5}}
+	tests := []struct {
+		name  string
+		spec  *common.DecaySpec
+		age   float64
+		value float64
+	}{
+		{"Orders without a decay model decay linearly", nil, 30, 70},
+		{"Linear", &common.DecaySpec{Model: shelflife.LinearDecay}, 30, 70},
+		{"Exponential starts at the shelf life", &common.DecaySpec{Model: shelflife.ExponentialDecay, Rate: 0.1}, 0,
+			100},
+		{"Exponential", &common.DecaySpec{Model: shelflife.ExponentialDecay, Rate: 0.1}, 10,
+			100 * (math.Exp(-1) - math.Exp(-10)) / (1 - math.Exp(-10))},
+		{"Exponential expires with the shelf life", &common.DecaySpec{Model: shelflife.ExponentialDecay, Rate: 0.1},
+			100, 0},
+		{"Piecewise interpolates", &common.DecaySpec{Model: shelflife.PiecewiseDecay, Points: points}, 15, 40},
+		{"Piecewise keeps the last value", &common.DecaySpec{Model: shelflife.PiecewiseDecay, Points: points}, 25, 0},
+		{"Table steps", &common.DecaySpec{Model: shelflife.TableDecay, Points: points}, 15, 80},
+		{"Table steps at the point", &common.DecaySpec{Model: shelflife.TableDecay, Points: points}, 20, 0},
+		{"Piecewise expires with the shelf life", &common.DecaySpec{Model: shelflife.PiecewiseDecay, Points: kept}, 100,
+			0},
+		{"Piecewise keeps a non-zero last value until then", &common.DecaySpec{Model: shelflife.PiecewiseDecay,
+			Points: kept}, 99, 50},
+		{"Table expires with the shelf life", &common.DecaySpec{Model: shelflife.TableDecay, Points: kept}, 100, 0},
+		{"Table keeps a non-zero last value until then", &common.DecaySpec{Model: shelflife.TableDecay, Points: kept},
+			99, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := shelflife.NewDecayModel(tt.spec)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.value, model.Value(100, tt.age), 1e-4)
+		})
+	}
+	t.Run("Invalid specs are rejected", func(t *testing.T) {
+		for _, spec := range []*common.DecaySpec{
+			{Model: "soggy"},
+			{Model: shelflife.ExponentialDecay},
+			{Model: shelflife.PiecewiseDecay},
+			{Model: shelflife.TableDecay, Points: []common.DecayPoint{{Age: 5, Value: 1}}},
+			{Model: shelflife.TableDecay, Points: []common.DecayPoint{{Age: 0, Value: 1}, {Age: 0, Value: 0.5}}},
+			{Model: shelflife.PiecewiseDecay, Points: []common.DecayPoint{{Age: 0, Value: 2}}},
+		} {
+			_, err := shelflife.NewDecayModel(spec)
+			assert.Error(t, err, "%+v", spec)
+		}
+	})
+	t.Run("The model applies to the effective age across shelves", func(t *testing.T) {
+		order := common.Order{ID: uuid.New(), ShelfLife: 100, DecayRate: 0.5,
+			Decay: &common.DecaySpec{Model: shelflife.TableDecay, Points: points}}
+		shelvedAt := time.Now()
+		s := shelflife.NewOrderState(&order, "hot", 1, shelvedAt)
+		// 4 seconds on the primary shelf age the order by 4 * (1 + 0.5), 2 on overflow by 2 * (1 + 2 * 0.5).
+		s.Place(common.OverflowShelfName, 2, shelvedAt.Add(4*time.Second))
+		value, err := s.Value(shelvedAt.Add(6 * time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, float32(80), value)
+		value, err = s.Value(shelvedAt.Add(5 * time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, float32(100), value)
+	})
+}
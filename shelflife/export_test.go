@@ -0,0 +1,15 @@
+package shelflife
+
+import "github.com/google/uuid"
+
+// StateOf returns a copy of the state of a tracked order, or found false if the service does not track the order.
+func StateOf(orderID uuid.UUID) (state OrderState, found bool) {
+	statesMu.Lock()
+	defer statesMu.Unlock()
+	s, found := orderStates[orderID]
+	if found {
+		state = *s
+		state.Placements = append([]Placement(nil), s.Placements...)
+	}
+	return
+}
@@ -1,11 +1,13 @@
 package shelflife
 
 import (
+	"context"
 	"fmt"
-	"github.com/cskr/pubsub"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"sort"
 	"stream-first/common"
+	"sync"
 	"time"
 )
 
@@ -15,61 +17,129 @@ import (
 // paused for testing.
 
 const (
-	serviceName             = "ShelfLife"
-	keepAliveTriggerSeconds = 1.0
+	serviceName = "ShelfLife"
 )
 
+// Placement records an order being placed on a shelf, and the decay modifier of the shelf at the time, see
+// common.ShelfLayout.DecayModifier.
+type Placement struct {
+	Shelf         string
+	DecayModifier float32
+	Dt            time.Time
+}
+
 // OrderState holds the information needed to calculate the order value.
 type OrderState struct {
 	Order *common.Order
+	// The shelf the order is on, the same as the shelf of the last placement.
 	Shelf string
-	// Order was placed on the primary shelf at....
-	// It will have the zero value if it was never on that shelf.
-	TimePlacedOnPrimaryShelf time.Time
-	// Order was placed on the overflow shelf at....
-	// It will have the zero value if it was never on that shelf.
-	TimePlacedOnOverflowShelf time.Time
+	// The shelves the order was placed on and when, oldest first.
+	Placements []Placement
+}
+
+func NewOrderState(order *common.Order, shelf string, decayModifier float32, dt time.Time) *OrderState {
+	return &OrderState{Order: order, Shelf: shelf,
+		Placements: []Placement{{Shelf: shelf, DecayModifier: decayModifier, Dt: dt}}}
+}
+
+// Place records moving the order to a shelf with the given decay modifier.
+func (s *OrderState) Place(shelf string, decayModifier float32, dt time.Time) {
+	s.Placements = append(s.Placements, Placement{Shelf: shelf, DecayModifier: decayModifier, Dt: dt})
+	s.Shelf = shelf
 }
 
 // Maps order IDs to order states.
-var OrderStates = map[uuid.UUID]*OrderState{}
+var orderStates = map[uuid.UUID]*OrderState{}
+
+// Guards orderStates while the service runs.
+var statesMu sync.Mutex
 
 func ResetStates() {
-	OrderStates = map[uuid.UUID]*OrderState{}
+	statesMu.Lock()
+	defer statesMu.Unlock()
+	orderStates = map[uuid.UUID]*OrderState{}
+}
+
+func forget(orderID uuid.UUID) {
+	statesMu.Lock()
+	defer statesMu.Unlock()
+	delete(orderStates, orderID)
+}
+
+// OrderValue is a snapshot of a shelved order, with its value at the time of the snapshot.
+type OrderValue struct {
+	Order     common.Order
+	Shelf     string
+	Value     float32
+	NormValue float32
+}
+
+// Values returns the shelved orders, ordered by ID, with their values at now.  It is safe to call while the service
+// runs.
+func Values(now time.Time) (values []OrderValue) {
+	statesMu.Lock()
+	defer statesMu.Unlock()
+	for _, state := range orderStates {
+		if value, err := state.snapshot(now); err == nil {
+			values = append(values, value)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Order.ID.String() < values[j].Order.ID.String()
+	})
+	return
+}
+
+// ValueOf returns the shelved order with its value at now, or found false if the order is not on a shelf or its
+// value cannot be calculated.  It is safe to call while the service runs.
+func ValueOf(orderID uuid.UUID, now time.Time) (value OrderValue, found bool) {
+	statesMu.Lock()
+	defer statesMu.Unlock()
+	state, found := orderStates[orderID]
+	if found {
+		value, err := state.snapshot(now)
+		return value, err == nil
+	}
+	return
+}
+
+func (s OrderState) snapshot(now time.Time) (OrderValue, error) {
+	value, err := s.Value(now)
+	return OrderValue{Order: *s.Order, Shelf: s.Shelf, Value: value, NormValue: value / s.Order.ShelfLife}, err
 }
 
 func (s OrderState) Value(now time.Time) (value float32, err error) {
-	if s.TimePlacedOnOverflowShelf.IsZero() && s.TimePlacedOnPrimaryShelf.IsZero() {
-		err = errors.Errorf("impossible: order was never shelved on neither primary nor overflow: %v", s.Order.ID)
+	if len(s.Placements) == 0 {
+		err = errors.Errorf("impossible: order was never shelved: %v", s.Order.ID)
 		return
 	}
-	if !s.TimePlacedOnOverflowShelf.IsZero() && !s.TimePlacedOnPrimaryShelf.IsZero() &&
-		s.TimePlacedOnOverflowShelf.After(s.TimePlacedOnPrimaryShelf) {
-		err = errors.Errorf("reshelved from primary to overflow, which should never happen, for Order %v", s.Order.ID)
+
+	model, err := NewDecayModel(s.Order.Decay)
+	if err != nil {
+		err = errors.Wrapf(err, "order %v", s.Order.ID)
 		return
 	}
 
-	// Calculate how long the orders spent on the primary shelf, and how long in overflow.
-	var durationAtOverflow, durationAtPrimary time.Duration
-
-	// If the order spent time on the overflow shelf
-	if !s.TimePlacedOnOverflowShelf.IsZero() {
-		if s.TimePlacedOnPrimaryShelf.IsZero() { // No time spent on primary shelf
-			durationAtOverflow = now.Sub(s.TimePlacedOnOverflowShelf)
-		} else { // Order was reshelved to primary
-			durationAtOverflow = s.TimePlacedOnPrimaryShelf.Sub(s.TimePlacedOnOverflowShelf)
+	// Add up the time spent on each shelf, and the decay while there, scaled by the decay modifier of the shelf.
+	var age time.Duration
+	var decay float64
+	for i, placement := range s.Placements {
+		until := now
+		if i+1 < len(s.Placements) {
+			until = s.Placements[i+1].Dt
+			if until.Before(placement.Dt) {
+				err = errors.Errorf("placements out of time order for Order %v", s.Order.ID)
+				return
+			}
 		}
+		duration := until.Sub(placement.Dt)
+		if duration < 0 { // Value asked for before the last placement.
+			duration = 0
+		}
+		age += duration
+		decay += float64(s.Order.DecayRate) * float64(placement.DecayModifier) * duration.Seconds()
 	}
-
-	// If the order spent time on the primary shelf
-	if !s.TimePlacedOnPrimaryShelf.IsZero() {
-		durationAtPrimary = now.Sub(s.TimePlacedOnPrimaryShelf)
-	}
-	age := durationAtPrimary + durationAtOverflow
-	decayWhileOnPrimary := float64(s.Order.DecayRate) * durationAtPrimary.Seconds()
-	// While on on overflow, orders decay at twice the normal rate.
-	decayWhileOnOverflow := float64(s.Order.DecayRate) * 2 * durationAtOverflow.Seconds()
-	value = float32(float64(s.Order.ShelfLife) - age.Seconds() - decayWhileOnPrimary - decayWhileOnOverflow)
+	value = float32(model.Value(float64(s.Order.ShelfLife), age.Seconds()+decay))
 
 	// Can't be more expired than expired.
 	if value < 0 {
@@ -78,25 +148,37 @@ func (s OrderState) Value(now time.Time) (value float32, err error) {
 	return
 }
 
-func Run(ps *pubsub.PubSub) {
+// Run publishes order values whenever orders move, and at least every keepAliveSeconds, until ctx is done.
+func Run(ctx context.Context, ps common.PubsubInterface, clock common.Clock, layout common.ShelfLayout,
+	keepAliveSeconds float64) {
 	shelvedCh := ps.Sub(common.ShelvedTopic)
 	reshelvedCh := ps.Sub(common.ReshelvedTopic)
 	pickupCh := ps.Sub(common.PickupTopic)
+	// Evicted orders leave the shelves without being picked up.
+	wasteCh := ps.Sub(common.WasteTopic)
 
 	// Allow time for other components to subscribe before starting to publish.
 	time.Sleep(common.Seconds(common.SchedulerDelay))
 
 	common.Diag(ps, serviceName, common.Info, "Service started.", nil)
 
-	// TODO: document the fact that for the ui, I chose once a second
-
-	Run0(ps, shelvedCh, reshelvedCh, pickupCh, nil)
+	Run0(ctx, ps, clock, layout, common.Seconds(keepAliveSeconds), shelvedCh, reshelvedCh, pickupCh, wasteCh)
 }
 
-func Run0(ps common.PubsubInterface,
-	shelvedCh chan interface{}, reshelvedCh chan interface{}, pickupCh chan interface{}, stopCh chan bool) {
+func Run0(ctx context.Context, ps common.PubsubInterface, clock common.Clock, layout common.ShelfLayout,
+	keepAlive time.Duration, shelvedCh chan interface{}, reshelvedCh chan interface{}, pickupCh chan interface{},
+	wasteCh chan interface{}) {
 
-	keepAliveCh := time.NewTimer(common.Seconds(keepAliveTriggerSeconds))
+	// The service publishes waste itself, so waste is read apart from the other events, lest publishing it blocks on
+	// a full channel that only the service reads.
+	wasteDone := make(chan struct{})
+	go func() {
+		defer close(wasteDone)
+		forgetEvicted(ctx, ps, wasteCh)
+	}()
+	defer func() { <-wasteDone }()
+
+	keepAliveCh := clock.NewTimer(keepAlive)
 
 	for {
 		select {
@@ -106,57 +188,76 @@ func Run0(ps common.PubsubInterface,
 				common.Diag(ps, serviceName, common.Error, common.CoerceErrorMessage(msg, e), nil)
 				continue
 			}
-			state, ok := OrderStates[e.Order.ID]
-			if !ok {
-				state = &OrderState{Order: &e.Order, Shelf: e.Shelf}
-				OrderStates[e.Order.ID] = state
+			if !layout.HasShelf(e.Shelf) {
+				common.Diag(ps, serviceName, common.Error, fmt.Sprintf("Order shelved on unknown shelf: %v", e.Shelf), nil)
+				continue
 			}
-			if e.Shelf == "overflow" {
-				state.TimePlacedOnOverflowShelf = e.Dt
+			statesMu.Lock()
+			if state, ok := orderStates[e.Order.ID]; ok {
+				state.Place(e.Shelf, layout.DecayModifier(e.Shelf), e.Dt)
 			} else {
-				state.TimePlacedOnPrimaryShelf = e.Dt
+				orderStates[e.Order.ID] = NewOrderState(&e.Order, e.Shelf, layout.DecayModifier(e.Shelf), e.Dt)
 			}
+			statesMu.Unlock()
 		case msg := <-reshelvedCh:
 			e, ok := msg.(*common.ReshelvedEvent)
 			if !ok {
 				common.Diag(ps, serviceName, common.Error, common.CoerceErrorMessage(msg, e), nil)
 				continue
 			}
+			if !layout.HasShelf(e.Shelf) {
+				common.Diag(ps, serviceName, common.Error, fmt.Sprintf("Order reshelved on unknown shelf: %v", e.Shelf), nil)
+				continue
+			}
+			statesMu.Lock()
+			state, ok := orderStates[e.OrderID]
+			if ok {
+				state.Place(e.Shelf, layout.DecayModifier(e.Shelf), e.Dt)
+			}
+			statesMu.Unlock()
 			// Order may have been picked up
-			state, ok := OrderStates[e.OrderID]
 			if !ok {
 				common.Diag(ps, serviceName, common.Warning, fmt.Sprintf("Reshelf failed, order not found: %v", e.OrderID), nil)
 				continue
 			}
-			state.Shelf = state.Order.Temp
-			state.TimePlacedOnPrimaryShelf = e.Dt
 		case msg := <-pickupCh:
 			e, ok := msg.(*common.PickupEvent)
 			if !ok {
 				common.Diag(ps, serviceName, common.Error, common.CoerceErrorMessage(msg, e), nil)
 				continue
 			}
-			delete(OrderStates, e.Order.ID)
-		case <-stopCh:
-			break
-		case <-keepAliveCh.C: // keep alive when other events are not coming
+			forget(e.Order.ID)
+		case <-ctx.Done():
+			keepAliveCh.Stop()
+			return
+		case <-keepAliveCh.C(): // keep alive when other events are not coming
 		}
 
-		now := time.Now()
-		for orderID, state := range OrderStates {
-			value, _ := state.Value(now)
-			if value <= 0 {
-				delete(OrderStates, orderID)
+		// Published once the states are unlocked, so that neither queries nor the waste reader wait on the bus.
+		now := clock.Now()
+		for _, v := range valueAll(now) {
+			state := v.state
+			switch {
+			case v.err != nil:
+				// Not an expiry: the order stays on its shelf, without a value, until it is picked up.
+				common.Diag(ps, serviceName, common.Error, "", errors.Wrap(v.err, "cannot value order, no longer tracked"))
+			case v.value <= 0:
 				ps.Pub(&common.ExpiredEvent{Dt: now, Order: *state.Order}, common.ExpiredTopic)
+				reason := common.WasteExpiredOnPrimary
+				if state.Shelf == common.OverflowShelfName {
+					reason = common.WasteExpiredOnOverflow
+				}
+				ps.Pub(&common.WasteEvent{Dt: now, Order: *state.Order, Shelf: state.Shelf, Reason: reason},
+					common.WasteTopic)
 				common.Diag(ps, serviceName, common.Warning, fmt.Sprintf("Waste - order expired: %+v", *state.Order), nil)
-			} else {
-				normValue := value / state.Order.ShelfLife
+			default:
+				normValue := v.value / state.Order.ShelfLife
 
 				ps.Pub(&common.ValueEvent{
 					Dt:        now,
 					Order:     *state.Order,
 					Shelf:     state.Shelf,
-					Value:     value,
+					Value:     v.value,
 					NormValue: normValue,
 				},
 					common.ValueTopic)
@@ -170,6 +271,50 @@ func Run0(ps common.PubsubInterface,
 		// appears to drain the channel, so using their example code causes deadlock.  Resetting without draining
 		// appears to work fine - the timer does not fire extraneously after Reset.
 
-		keepAliveCh.Reset(common.Seconds(keepAliveTriggerSeconds))
+		keepAliveCh.Reset(keepAlive)
+	}
+}
+
+// The value of a tracked order, or the error calculating it.
+type valuation struct {
+	state OrderState
+	value float32
+	err   error
+}
+
+// Value the tracked orders at now, ordered by ID.  Orders that expired or cannot be valued are no longer tracked.
+func valueAll(now time.Time) (valuations []valuation) {
+	statesMu.Lock()
+	defer statesMu.Unlock()
+	for _, state := range orderStates {
+		value, err := state.Value(now)
+		if err != nil || value <= 0 {
+			delete(orderStates, state.Order.ID)
+		}
+		valuations = append(valuations, valuation{state: *state, value: value, err: err})
+	}
+	sort.Slice(valuations, func(i, j int) bool {
+		return valuations[i].state.Order.ID.String() < valuations[j].state.Order.ID.String()
+	})
+	return
+}
+
+// Stop tracking evicted orders, until ctx is done.  Other waste is of orders that expired, which the service no
+// longer tracks, or that were never shelved.
+func forgetEvicted(ctx context.Context, ps common.PubsubInterface, wasteCh chan interface{}) {
+	for {
+		select {
+		case msg := <-wasteCh:
+			e, ok := msg.(*common.WasteEvent)
+			if !ok {
+				common.Diag(ps, serviceName, common.Error, common.CoerceErrorMessage(msg, e), nil)
+				continue
+			}
+			if e.Reason == common.WasteEvicted {
+				forget(e.Order.ID)
+			}
+		case <-ctx.Done():
+			return
+		}
 	}
 }
@@ -1,6 +1,8 @@
 package shelflife_test
 
 import (
+	"context"
+	"github.com/cskr/pubsub"
 	"github.com/stretchr/testify/mock"
 	"stream-first/common"
 	"stream-first/mocks"
@@ -18,12 +20,23 @@ const timeFormat = "2006-01-02 15:04:05"
 var testOrder = common.Order{ID: uuid.New(), Name: "an order", Temp: "hot", ShelfLife: 100, DecayRate: 1}
 
 func Test_orderState_Value(t *testing.T) {
+	// Cold orders keep better than hot ones, overflow keeps the default modifier.
+	coldModifier := float32(0.5)
+	layout := common.ShelfLayout{
+		Shelves: []common.ShelfDefinition{
+			{Temp: "hot", Capacity: 1}, {Temp: "cold", Capacity: 1, DecayModifier: &coldModifier}},
+		OverflowCapacity: 1,
+	}
+	type placement struct {
+		shelf string
+		// Seconds since the order was first shelved.
+		at float64
+	}
 	type fields struct {
-		shelfLife                       float32
-		decayRate                       float32
-		timePlacedOnPrimaryShelfString  string
-		timePlacedOnOverflowShelfString string
-		secondsSinceShelving            float64
+		shelfLife            float32
+		decayRate            float32
+		placements           []placement
+		secondsSinceShelving float64
 	}
 	tests := []struct {
 		name      string
@@ -32,60 +45,88 @@ func Test_orderState_Value(t *testing.T) {
 		wantErr   bool
 	}{
 		{
-			name:    "Value returns error if neither start times is set",
+			name:    "Value returns error if the order was never placed on a shelf",
 			fields:  fields{},
 			wantErr: true,
 		},
 		{
-			name: "Value returns error if reshelved from primary to overflow",
+			name: "Value returns error if placements are out of time order",
 			fields: fields{
-				timePlacedOnPrimaryShelfString:  "2019-01-02 15:04:05",
-				timePlacedOnOverflowShelfString: "2019-01-02 15:05:07",
+				placements: []placement{{"hot", 5}, {common.OverflowShelfName, 2}},
 			},
 			wantErr: true,
 		},
 		{
 			name: "Value subtracts age and age times decay rate if order was always on a primary shelf",
 			fields: fields{
-				shelfLife:                      100,
-				decayRate:                      0.1,
-				timePlacedOnPrimaryShelfString: "2019-01-02 15:04:05",
-				secondsSinceShelving:           7,
+				shelfLife:            100,
+				decayRate:            0.1,
+				placements:           []placement{{"hot", 0}},
+				secondsSinceShelving: 7,
 			},
 			wantValue: 100 - 7 - 0.7,
 		},
 		{
 			name: "Value subtracts age and age times twice decay rate if order was always on a overflow shelf",
 			fields: fields{
-				shelfLife:                       100,
-				decayRate:                       0.1,
-				timePlacedOnOverflowShelfString: "2019-01-02 15:04:05",
-				secondsSinceShelving:            4,
+				shelfLife:            100,
+				decayRate:            0.1,
+				placements:           []placement{{common.OverflowShelfName, 0}},
+				secondsSinceShelving: 4,
 			},
 			wantValue: 100 - 4 - 2*0.4,
 		},
 		{
 			name: "Value considers both decay rates for orders that were reshelved from overflow to primary",
 			fields: fields{
-				shelfLife:                       100,
-				decayRate:                       0.1,
-				timePlacedOnOverflowShelfString: "2019-01-02 15:04:05",
-				// Spent 2 seconds on overflow...
-				timePlacedOnPrimaryShelfString: "2019-01-02 15:04:07",
-				// ... then 3 seconds on primary
+				shelfLife: 100,
+				decayRate: 0.1,
+				// Spent 2 seconds on overflow, then 3 seconds on primary
+				placements:           []placement{{common.OverflowShelfName, 0}, {"hot", 2}},
 				secondsSinceShelving: 5,
 			},
 			wantValue: 100 - 5 - 2*(2*0.1) - 3*0.1,
 		},
+		{
+			name: "Value considers both decay rates for orders that were moved from primary to overflow",
+			fields: fields{
+				shelfLife: 100,
+				decayRate: 0.1,
+				// Spent 3 seconds on primary, then 2 seconds on overflow
+				placements:           []placement{{"hot", 0}, {common.OverflowShelfName, 3}},
+				secondsSinceShelving: 5,
+			},
+			wantValue: 100 - 5 - 3*0.1 - 2*(2*0.1),
+		},
+		{
+			name: "Value adds up the decay over every placement",
+			fields: fields{
+				shelfLife: 100,
+				decayRate: 0.1,
+				placements: []placement{
+					{"hot", 0}, {common.OverflowShelfName, 1}, {"hot", 3}, {common.OverflowShelfName, 6}},
+				secondsSinceShelving: 10,
+			},
+			wantValue: 100 - 10 - (1+3)*0.1 - (2+4)*(2*0.1),
+		},
+		{
+			name: "Value applies the decay modifier of each shelf visited",
+			fields: fields{
+				shelfLife: 100,
+				decayRate: 0.1,
+				// Spent 2 seconds on hot, 4 on cold and 3 on overflow
+				placements:           []placement{{"hot", 0}, {"cold", 2}, {common.OverflowShelfName, 6}},
+				secondsSinceShelving: 9,
+			},
+			wantValue: 100 - 9 - 2*0.1 - 4*(0.5*0.1) - 3*(2*0.1),
+		},
 		{
 			name: "Value returns 0 once it the order expiration time arrives",
 			fields: fields{
-				shelfLife:                       10,
-				decayRate:                       0.1,
-				timePlacedOnOverflowShelfString: "2019-01-02 15:04:05",
-				// Spent 2 seconds on overflow...
-				timePlacedOnPrimaryShelfString: "2019-01-02 15:04:07",
-				// ... then given plenty of time to expire
+				shelfLife: 10,
+				decayRate: 0.1,
+				// Spent 2 seconds on overflow, then given plenty of time to expire
+				placements:           []placement{{common.OverflowShelfName, 0}, {"hot", 2}},
 				secondsSinceShelving: 15,
 			},
 			wantValue: 0,
@@ -94,22 +135,14 @@ func Test_orderState_Value(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// "now" is a bit of a misnomer - it refers to the time for which order value is calculated.
-			var timePlacedOnOverflowShelf, timePlacedOnPrimaryShelf, now time.Time
-
-			if tt.fields.timePlacedOnOverflowShelfString != "" {
-				timePlacedOnOverflowShelf, _ = time.Parse(timeFormat, tt.fields.timePlacedOnOverflowShelfString)
-			}
-
-			if tt.fields.timePlacedOnPrimaryShelfString != "" {
-				timePlacedOnPrimaryShelf, _ = time.Parse(timeFormat, tt.fields.timePlacedOnPrimaryShelfString)
-			}
-
-			now = minTime(timePlacedOnOverflowShelf, timePlacedOnPrimaryShelf).Add(common.Seconds(tt.fields.secondsSinceShelving))
+			shelvedAt, _ := time.Parse(timeFormat, "2019-01-02 15:04:05")
+			now := shelvedAt.Add(common.Seconds(tt.fields.secondsSinceShelving))
 
 			s := shelflife.OrderState{
-				Order:                     &common.Order{ID: uuid.New(), ShelfLife: tt.fields.shelfLife, DecayRate: tt.fields.decayRate},
-				TimePlacedOnPrimaryShelf:  timePlacedOnPrimaryShelf,
-				TimePlacedOnOverflowShelf: timePlacedOnOverflowShelf,
+				Order: &common.Order{ID: uuid.New(), ShelfLife: tt.fields.shelfLife, DecayRate: tt.fields.decayRate},
+			}
+			for _, p := range tt.fields.placements {
+				s.Place(p.shelf, layout.DecayModifier(p.shelf), shelvedAt.Add(common.Seconds(p.at)))
 			}
 
 			gotValue, err := s.Value(now)
@@ -117,7 +150,7 @@ func Test_orderState_Value(t *testing.T) {
 				assert.Error(t, err)
 			} else {
 				require.NoError(t, err)
-				assert.Equal(t, tt.wantValue, gotValue)
+				assert.InDelta(t, tt.wantValue, gotValue, 1e-4)
 			}
 		})
 	}
@@ -125,71 +158,189 @@ func Test_orderState_Value(t *testing.T) {
 
 func TestRun0(t *testing.T) {
 	t.Run("Order state recorded when the order is shelved to primary", func(t *testing.T) {
-		ps, shelvedCh, reShelvedCh, pickupCh, stopCh := initRun()
+		ctx, stop, ps, shelvedCh, reShelvedCh, pickupCh, wasteCh := initRun()
 		ps.On("Pub", mock.Anything, mock.Anything)
 
-		go shelflife.Run0(ps, shelvedCh, reShelvedCh, pickupCh, stopCh)
-		defer func() { stopCh <- true }()
+		go shelflife.Run0(ctx, ps, common.NewSimClock(time.Now()), common.DefaultShelfLayout, time.Second, shelvedCh, reShelvedCh, pickupCh,
+			wasteCh)
+		defer stop()
 
 		// Order not yet recorded
-		require.Nil(t, shelflife.OrderStates[testOrder.ID])
+		requireUntracked(t, testOrder.ID)
 		timeShelved := time.Now()
 		// Shelve it
 		shelvedCh <- &common.ShelvedEvent{Dt: timeShelved, Order: testOrder, Shelf: testOrder.Temp}
 		time.Sleep(common.Seconds(common.SchedulerDelay))
-		require.NotNil(t, shelflife.OrderStates[testOrder.ID])
 		require.Equal(t,
 			shelflife.OrderState{
-				TimePlacedOnPrimaryShelf: timeShelved,
-				Order:                    &testOrder,
-				Shelf:                    testOrder.Temp,
+				Order:      &testOrder,
+				Shelf:      testOrder.Temp,
+				Placements: []shelflife.Placement{{Shelf: testOrder.Temp, DecayModifier: 1, Dt: timeShelved}},
 			},
-			*shelflife.OrderStates[testOrder.ID])
+			requireTracked(t, testOrder.ID))
 	})
 	t.Run("Order state recorded when the order is shelved to overflow", func(t *testing.T) {
-		ps, shelvedCh, reShelvedCh, pickupCh, stopCh := initRun()
+		ctx, stop, ps, shelvedCh, reShelvedCh, pickupCh, wasteCh := initRun()
 		ps.On("Pub", mock.Anything, mock.Anything)
 
-		go shelflife.Run0(ps, shelvedCh, reShelvedCh, pickupCh, stopCh)
-		defer func() { stopCh <- true }()
+		go shelflife.Run0(ctx, ps, common.NewSimClock(time.Now()), common.DefaultShelfLayout, time.Second, shelvedCh, reShelvedCh, pickupCh,
+			wasteCh)
+		defer stop()
 
 		// Order not yet recorded
-		require.Nil(t, shelflife.OrderStates[testOrder.ID])
+		requireUntracked(t, testOrder.ID)
 		timeShelved := time.Now()
 		// Shelve it
 		shelvedCh <- &common.ShelvedEvent{Dt: timeShelved, Order: testOrder, Shelf: "overflow"}
 		time.Sleep(common.Seconds(common.SchedulerDelay))
-		require.NotNil(t, shelflife.OrderStates[testOrder.ID])
 		require.Equal(t,
 			shelflife.OrderState{
-				TimePlacedOnOverflowShelf: timeShelved,
-				Order:                     &testOrder,
-				Shelf:                     "overflow",
+				Order:      &testOrder,
+				Shelf:      "overflow",
+				Placements: []shelflife.Placement{{Shelf: "overflow", DecayModifier: 2, Dt: timeShelved}},
+			},
+			requireTracked(t, testOrder.ID))
+	})
+	t.Run("Order state follows reshelving in both directions", func(t *testing.T) {
+		ctx, stop, ps, shelvedCh, reShelvedCh, pickupCh, wasteCh := initRun()
+		ps.On("Pub", mock.Anything, mock.Anything)
+
+		go shelflife.Run0(ctx, ps, common.NewSimClock(time.Now()), common.DefaultShelfLayout, time.Second, shelvedCh, reShelvedCh, pickupCh,
+			wasteCh)
+		defer stop()
+
+		timeShelved := time.Now()
+		shelvedCh <- &common.ShelvedEvent{Dt: timeShelved, Order: testOrder, Shelf: testOrder.Temp}
+		reShelvedCh <- &common.ReshelvedEvent{Dt: timeShelved.Add(time.Second), OrderID: testOrder.ID, Shelf: "overflow"}
+		reShelvedCh <- &common.ReshelvedEvent{Dt: timeShelved.Add(2 * time.Second), OrderID: testOrder.ID, Shelf: testOrder.Temp}
+		time.Sleep(common.Seconds(common.SchedulerDelay))
+		state := requireTracked(t, testOrder.ID)
+		require.Equal(t,
+			[]shelflife.Placement{
+				{Shelf: testOrder.Temp, DecayModifier: 1, Dt: timeShelved},
+				{Shelf: "overflow", DecayModifier: 2, Dt: timeShelved.Add(time.Second)},
+				{Shelf: testOrder.Temp, DecayModifier: 1, Dt: timeShelved.Add(2 * time.Second)},
 			},
-			*shelflife.OrderStates[testOrder.ID])
+			state.Placements)
+		assert.Equal(t, testOrder.Temp, state.Shelf)
 	})
 }
 
-func initRun() (ps *mocks.MockPubsub, shelvedCh chan interface{}, reShelvedCh chan interface{}, pickupCh chan interface{}, stopCh chan bool) {
+func TestRun0_evicted(t *testing.T) {
+	ctx, stop, ps, shelvedCh, reShelvedCh, pickupCh, wasteCh := initRun()
+	ps.On("Pub", mock.Anything, mock.Anything)
+	clock := common.NewSimClock(time.Now())
+
+	go shelflife.Run0(ctx, ps, clock, common.DefaultShelfLayout, time.Second, shelvedCh, reShelvedCh, pickupCh,
+		wasteCh)
+	defer stop()
+
+	shelvedCh <- &common.ShelvedEvent{Dt: clock.Now(), Order: testOrder, Shelf: testOrder.Temp}
+	wasteCh <- &common.WasteEvent{Dt: clock.Now(), Order: testOrder, Shelf: testOrder.Temp,
+		Reason: common.WasteEvicted}
+	time.Sleep(common.Seconds(common.SchedulerDelay))
+	requireUntracked(t, testOrder.ID)
+}
+
+func TestRun_smallBuffer(t *testing.T) {
+	t.Run("Orders expiring together do not block the service on its own waste subscription", func(t *testing.T) {
+		shelflife.ResetStates()
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		ps := pubsub.New(1)
+		valueCh, expiredCh := ps.Sub(common.ValueTopic), ps.Sub(common.ExpiredTopic)
+		clock := common.NewSimClock(time.Now())
+		go shelflife.Run(ctx, ps, clock, common.DefaultShelfLayout, 1)
+		// Allow time for the service to subscribe.
+		time.Sleep(common.Seconds(10 * common.SchedulerDelay))
+
+		const n = 10
+		for i := 0; i < n; i++ {
+			order := common.Order{ID: uuid.New(), Name: "short lived", Temp: "hot", ShelfLife: 1, DecayRate: 1}
+			ps.Pub(&common.ShelvedEvent{Dt: clock.Now(), Order: order, Shelf: order.Temp}, common.ShelvedTopic)
+			// Wait for the values of the orders shelved so far, so that the service is done with this one.
+			for j := 0; j <= i; j++ {
+				<-valueCh
+			}
+		}
+		deadline := time.After(time.Second)
+		for expired := 0; expired < n; {
+			select {
+			case <-expiredCh:
+				expired++
+			case <-time.After(common.Seconds(common.SchedulerDelay)):
+				clock.Advance(time.Second)
+			case <-deadline:
+				require.FailNowf(t, "service blocked", "%v of %v orders expired", expired, n)
+			}
+		}
+		assert.Empty(t, shelflife.Values(clock.Now()))
+	})
+}
+
+func TestRun0_expiry(t *testing.T) {
+	t.Run("Expired orders are reported as waste with the shelf they expired on", func(t *testing.T) {
+		ctx, stop, ps, shelvedCh, reShelvedCh, pickupCh, wasteCh := initRun()
+		ps.On("Pub", mock.Anything, mock.Anything)
+		clock := common.NewSimClock(time.Now())
+
+		go shelflife.Run0(ctx, ps, clock, common.DefaultShelfLayout, time.Second, shelvedCh, reShelvedCh, pickupCh,
+			wasteCh)
+		defer stop()
+
+		order := common.Order{ID: uuid.New(), Name: "short lived", Temp: "hot", ShelfLife: 1, DecayRate: 1}
+		shelvedCh <- &common.ShelvedEvent{Dt: clock.Now(), Order: order, Shelf: common.OverflowShelfName}
+		clock.BlockUntil(1)
+		clock.Advance(time.Second)
+		time.Sleep(common.Seconds(common.SchedulerDelay))
+
+		ps.AssertCalled(t, "Pub", &common.ExpiredEvent{Dt: clock.Now(), Order: order}, []string{common.ExpiredTopic})
+		ps.AssertCalled(t, "Pub", &common.WasteEvent{Dt: clock.Now(), Order: order, Shelf: common.OverflowShelfName,
+			Reason: common.WasteExpiredOnOverflow}, []string{common.WasteTopic})
+		requireUntracked(t, order.ID)
+	})
+	t.Run("Orders that cannot be valued are reported, not expired", func(t *testing.T) {
+		ctx, stop, ps, shelvedCh, reShelvedCh, pickupCh, wasteCh := initRun()
+		ps.On("Pub", mock.Anything, mock.Anything)
+		clock := common.NewSimClock(time.Now())
+
+		go shelflife.Run0(ctx, ps, clock, common.DefaultShelfLayout, time.Second, shelvedCh, reShelvedCh, pickupCh,
+			wasteCh)
+		defer stop()
+
+		order := common.Order{ID: uuid.New(), Name: "soggy", Temp: "hot", ShelfLife: 100, DecayRate: 1,
+			Decay: &common.DecaySpec{Model: "soggy"}}
+		shelvedCh <- &common.ShelvedEvent{Dt: clock.Now(), Order: order, Shelf: "hot"}
+		time.Sleep(common.Seconds(common.SchedulerDelay))
+
+		ps.AssertCalled(t, "Pub", mock.AnythingOfType("*common.DiagEvent"), []string{common.DiagTopic})
+		ps.AssertNotCalled(t, "Pub", mock.Anything, []string{common.ExpiredTopic})
+		ps.AssertNotCalled(t, "Pub", mock.Anything, []string{common.WasteTopic})
+		requireUntracked(t, order.ID)
+	})
+}
+
+// Fail unless the service tracks the order, and return its state.
+func requireTracked(t *testing.T, orderID uuid.UUID) shelflife.OrderState {
+	state, found := shelflife.StateOf(orderID)
+	require.True(t, found, "order %v not tracked", orderID)
+	return state
+}
+
+func requireUntracked(t *testing.T, orderID uuid.UUID) {
+	_, found := shelflife.StateOf(orderID)
+	require.False(t, found, "order %v tracked", orderID)
+}
+
+func initRun() (ctx context.Context, stop context.CancelFunc, ps *mocks.MockPubsub, shelvedCh chan interface{},
+	reShelvedCh chan interface{}, pickupCh chan interface{}, wasteCh chan interface{}) {
+	ctx, stop = context.WithCancel(context.Background())
 	ps = &mocks.MockPubsub{}
 	shelvedCh = make(chan interface{})
 	reShelvedCh = make(chan interface{})
 	pickupCh = make(chan interface{})
-	stopCh = make(chan bool)
+	wasteCh = make(chan interface{})
 	// Start every test with an empty states store.
 	shelflife.ResetStates()
 	return
 }
-
-func minTime(t1 time.Time, t2 time.Time) time.Time {
-	if t1.IsZero() {
-		return t2
-	}
-	if t2.IsZero() {
-		return t1
-	}
-	if t1.Before(t2) {
-		return t1
-	}
-	return t2
-}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"stream-first/common"
+	"stream-first/config"
+	"stream-first/natsbus"
+	"stream-first/supervisor"
+	"syscall"
+	"time"
+)
+
+const (
+	// Address of the stand-in NATS server when no -bus url is given.
+	defaultBusAddr = "127.0.0.1:4222"
+	restartDelay   = time.Second
+	stopTimeout    = 5 * time.Second
+)
+
+// Run a stand-in NATS server on the host and port of the -bus url, for services in separate processes.
+func runBus(args []string) {
+	cfg := loadConfig(busCommand, args)
+	addr := defaultBusAddr
+	if cfg.Bus != config.LocalBus {
+		u, err := url.Parse(cfg.Bus)
+		if err != nil {
+			exitOnError(err)
+		}
+		addr = u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "4222")
+		}
+	}
+	server, err := natsbus.NewServer(addr)
+	if err != nil {
+		exitOnError(err)
+	}
+	fmt.Fprintf(os.Stderr, "Bus listening on %v\n", server.URL())
+	exitOnError(server.Serve())
+}
+
+// Run each service as a child process of this one, with the same flags.  Without a -bus url, the services talk over
+// a stand-in NATS server run by the supervisor.  The run ends when the UI service does.
+func runSupervisor(args []string) {
+	cfg := loadConfig(superviseCommand, args)
+	busURL := cfg.Bus
+	if busURL == config.LocalBus {
+		server, err := natsbus.NewServer("127.0.0.1:0")
+		if err != nil {
+			exitOnError(err)
+		}
+		go func() { _ = server.Serve() }()
+		busURL = server.URL()
+	}
+	// All services draw from the same seed, so that the run can be repeated.
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = common.NewSeed()
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		exitOnError(err)
+	}
+	child := func(service string) supervisor.Child {
+		command := append([]string{executable, service}, args...)
+		command = append(command, "-bus", busURL, "-seed", strconv.FormatUint(seed, 10))
+		return supervisor.Child{Name: service, Command: command}
+	}
+
+	ui := child(uiService)
+	ui.Primary = true
+	// The order sender starts last, so that no order is sent before the other services subscribed.
+	orderSender := child(orderSenderService)
+	orderSender.AwaitReady = true
+	s := supervisor.Supervisor{
+		Children: []supervisor.Child{
+			child(kitchenService), child(shelfService), child(shelfLifeService), child(pickupService), ui,
+			orderSender,
+		},
+		RestartDelay: restartDelay,
+		StopTimeout:  stopTimeout,
+		Stdout:       os.Stdout,
+		Stderr:       os.Stderr,
+	}
+	// Ctrl-C reaches the children too, SIGTERM is passed on by the supervisor.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	exitCode := s.Run(ctx)
+	stop()
+	os.Exit(exitCode)
+}
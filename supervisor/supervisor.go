@@ -0,0 +1,194 @@
+package supervisor
+
+// The supervisor package runs services as child processes, restarting those that fail, so that each can be scaled
+// and restarted on its own while a single command still runs the whole simulation.
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ReadyEnv names the environment variable that holds the file descriptor children tell they are ready on, see
+// NotifyReady.
+const ReadyEnv = "SUPERVISOR_READY_FD"
+
+// Child is a process run by the supervisor.
+type Child struct {
+	Name string
+	// The program and its arguments.
+	Command []string
+	// The child is started once the other children told they are ready with NotifyReady, for example so that they
+	// subscribed first.
+	AwaitReady bool
+	// The run ends when the primary child exits, with its exit code.  The primary child gets the supervisor's
+	// standard input, other children are restarted when they fail.
+	Primary bool
+}
+
+// Supervisor runs the children.  Exactly one child must be primary.
+type Supervisor struct {
+	Children []Child
+	// How long a failed child stays down before it is restarted.
+	RestartDelay time.Duration
+	// How long children get to exit once they are asked to terminate, before they are killed.  Children are asked to
+	// terminate when the primary child exits, or when the run is stopped.
+	StopTimeout time.Duration
+	// Where the output of the children, and the supervisor's own messages, go.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Run starts the children, and returns the exit code of the primary child once it and the other children are done.
+// When ctx is done, all children are stopped.
+func (s *Supervisor) Run(ctx context.Context) (exitCode int) {
+	// Done once the primary child exits.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	primaryCh := make(chan int, 1)
+	// Closed once each child that doesn't wait for the others is ready.
+	var readyChs []chan bool
+	for _, c := range s.Children {
+		if !c.AwaitReady {
+			readyChs = append(readyChs, make(chan bool))
+		}
+	}
+	var wg sync.WaitGroup
+	i := 0
+	for _, c := range s.Children {
+		var readyCh chan bool
+		if !c.AwaitReady {
+			readyCh = readyChs[i]
+			i++
+		}
+		wg.Add(1)
+		go func(c Child) {
+			defer wg.Done()
+			s.supervise(ctx, c, readyCh, readyChs, primaryCh)
+		}(c)
+	}
+	exitCode = <-primaryCh
+	stop()
+	wg.Wait()
+	return exitCode
+}
+
+// Run a child until ctx is done, restarting it when it fails.  readyCh, unless nil, is closed once the child is ready,
+// and a child that awaits the others first waits for all of readyChs.  The exit code of the primary child is sent on
+// primaryCh.
+func (s *Supervisor) supervise(ctx context.Context, c Child, readyCh chan bool, readyChs []chan bool,
+	primaryCh chan int) {
+	exitCode := 1
+	if c.Primary {
+		defer func() { primaryCh <- exitCode }()
+	}
+	if c.AwaitReady {
+		for _, otherCh := range readyChs {
+			select {
+			case <-otherCh:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	var readyOnce sync.Once
+	for {
+		cmd := exec.Command(c.Command[0], c.Command[1:]...)
+		cmd.Stdout, cmd.Stderr = s.Stdout, s.Stderr
+		if c.Primary {
+			cmd.Stdin = os.Stdin
+		}
+		readyR, readyW, err := os.Pipe()
+		if err != nil {
+			s.logf("%v failed to start: %v", c.Name, err)
+			return
+		}
+		// The first extra file is descriptor 3 in the child.
+		cmd.ExtraFiles = []*os.File{readyW}
+		cmd.Env = append(os.Environ(), ReadyEnv+"=3")
+		err = cmd.Start()
+		// The child has its own copy.
+		_ = readyW.Close()
+		go func() {
+			defer func() { _ = readyR.Close() }()
+			// A child that exits without telling closes the pipe instead.
+			if _, err := bufio.NewReader(readyR).ReadString('\n'); err == nil && readyCh != nil {
+				readyOnce.Do(func() { close(readyCh) })
+			}
+		}()
+		if err != nil {
+			s.logf("%v failed to start: %v", c.Name, err)
+			if c.Primary {
+				return
+			}
+		} else {
+			doneCh := make(chan error, 1)
+			go func() { doneCh <- cmd.Wait() }()
+			var err error
+			select {
+			case err = <-doneCh:
+			case <-ctx.Done():
+				err = s.stop(c, cmd, doneCh)
+			}
+			exitCode = exitCodeOf(err)
+			// A service that ends cleanly is done, for example after a quit request.
+			if c.Primary || ctx.Err() != nil || err == nil {
+				return
+			}
+			s.logf("%v failed: %v, restarting in %v", c.Name, err, s.RestartDelay)
+		}
+		select {
+		case <-time.After(s.RestartDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Ask a child to terminate, and kill it if it doesn't within StopTimeout.  Returns the error of cmd.Wait.
+func (s *Supervisor) stop(c Child, cmd *exec.Cmd, doneCh chan error) error {
+	_ = cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case err := <-doneCh:
+		return err
+	case <-time.After(s.StopTimeout):
+		_ = cmd.Process.Kill()
+		s.logf("%v killed, it didn't exit within %v", c.Name, s.StopTimeout)
+		return <-doneCh
+	}
+}
+
+func (s *Supervisor) logf(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(s.Stderr, "Supervisor: "+format+"\n", args...)
+}
+
+// The exit code of a process from the error returned by cmd.Wait.
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
+
+// NotifyReady tells the supervisor that the calling child process is ready.  It does nothing in processes that aren't
+// run by a supervisor.
+func NotifyReady() error {
+	fd, err := strconv.Atoi(os.Getenv(ReadyEnv))
+	if err != nil {
+		return nil
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	defer func() { _ = f.Close() }()
+	_, err = f.WriteString("ready\n")
+	return err
+}
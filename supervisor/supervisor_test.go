@@ -0,0 +1,142 @@
+package supervisor_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"stream-first/supervisor"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A buffer the children can write to concurrently.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func sh(script string) []string {
+	return []string{"sh", "-c", script}
+}
+
+func TestSupervisor_Run(t *testing.T) {
+	t.Run("the primary exit code ends the run, and the others are stopped", func(t *testing.T) {
+		var stderr syncBuffer
+		s := supervisor.Supervisor{
+			Children: []supervisor.Child{
+				{Name: "primary", Command: sh("sleep 0.2; exit 3"), Primary: true},
+				{Name: "stuck", Command: sh("trap '' TERM; exec sleep 10")},
+				{Name: "late", Command: sh("touch never"), AwaitReady: true},
+			},
+			RestartDelay: 10 * time.Millisecond,
+			StopTimeout:  100 * time.Millisecond,
+			Stdout:       &syncBuffer{},
+			Stderr:       &stderr,
+		}
+		start := time.Now()
+		assert.Equal(t, 3, s.Run(context.Background()))
+		assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+		assert.Contains(t, stderr.String(), "stuck killed")
+	})
+	t.Run("failed children are restarted, finished ones are not", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "supervisor")
+		require.NoError(t, err)
+		defer func() { _ = os.RemoveAll(dir) }()
+		failing, finishing := filepath.Join(dir, "failing"), filepath.Join(dir, "finishing")
+		var stdout, stderr syncBuffer
+		s := supervisor.Supervisor{
+			Children: []supervisor.Child{
+				{Name: "primary", Command: sh("sleep 0.5; echo done"), Primary: true},
+				{Name: "failing", Command: sh("echo run >> " + failing + "; exit 1")},
+				{Name: "finishing", Command: sh("echo run >> " + finishing)},
+			},
+			RestartDelay: 50 * time.Millisecond,
+			StopTimeout:  time.Second,
+			Stdout:       &stdout,
+			Stderr:       &stderr,
+		}
+		assert.Equal(t, 0, s.Run(context.Background()))
+		assert.Equal(t, "done\n", stdout.String())
+		runs, err := ioutil.ReadFile(failing)
+		require.NoError(t, err)
+		assert.Greater(t, strings.Count(string(runs), "run"), 1)
+		assert.Contains(t, stderr.String(), "failing failed: exit status 1")
+		runs, err = ioutil.ReadFile(finishing)
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(string(runs), "run"))
+	})
+	t.Run("children awaiting the others start once those are ready", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "supervisor")
+		require.NoError(t, err)
+		defer func() { _ = os.RemoveAll(dir) }()
+		log := filepath.Join(dir, "log")
+		ready := func(name string) []string {
+			return sh("sleep 0.2; echo " + name + " >> " + log + "; echo ready >&$" + supervisor.ReadyEnv +
+				"; exec sleep 10")
+		}
+		s := supervisor.Supervisor{
+			Children: []supervisor.Child{
+				{Name: "primary", Command: sh("echo ready >&$" + supervisor.ReadyEnv + "; sleep 1"), Primary: true},
+				{Name: "last", Command: sh("echo last >> " + log), AwaitReady: true},
+				{Name: "first", Command: ready("first")},
+				{Name: "second", Command: ready("second")},
+			},
+			StopTimeout: 100 * time.Millisecond,
+			Stdout:      &syncBuffer{},
+			Stderr:      &syncBuffer{},
+		}
+		assert.Equal(t, 0, s.Run(context.Background()))
+		lines, err := ioutil.ReadFile(log)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"first", "second", "last"}, strings.Fields(string(lines)))
+		assert.Equal(t, "last", strings.Fields(string(lines))[2])
+	})
+	t.Run("stopping the run terminates the children", func(t *testing.T) {
+		var stderr syncBuffer
+		s := supervisor.Supervisor{
+			Children: []supervisor.Child{
+				{Name: "primary", Command: sh("trap 'exit 5' TERM; sleep 10 >/dev/null 2>&1 & wait"), Primary: true},
+				{Name: "other", Command: sh("exec sleep 10")},
+			},
+			StopTimeout: 5 * time.Second,
+			Stdout:      &syncBuffer{},
+			Stderr:      &stderr,
+		}
+		ctx, stop := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer stop()
+		start := time.Now()
+		assert.Equal(t, 5, s.Run(ctx))
+		assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+		assert.NotContains(t, stderr.String(), "killed")
+	})
+	t.Run("a primary that can't start fails the run", func(t *testing.T) {
+		var stderr syncBuffer
+		s := supervisor.Supervisor{
+			Children:    []supervisor.Child{{Name: "primary", Command: []string{"/nonexistent"}, Primary: true}},
+			StopTimeout: time.Second,
+			Stdout:      &syncBuffer{},
+			Stderr:      &stderr,
+		}
+		assert.Equal(t, 1, s.Run(context.Background()))
+		assert.Contains(t, stderr.String(), "primary failed to start")
+	})
+}
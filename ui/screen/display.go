@@ -1,13 +1,13 @@
 package screen
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"stream-first/common"
 	"stream-first/ui/userrequests"
 	"time"
 
-	"github.com/cskr/pubsub"
 	"github.com/google/uuid"
 
 	tm "github.com/buger/goterm"
@@ -73,27 +73,33 @@ type orderState struct {
 
 func (s orderState) String() string {
 	var tempVis = map[string]string{"frozen": "[..]", "cold": "[--]", "hot": "[^^]"}
-	return fmt.Sprintf("%v %4.3f : %-6.3f : %v\n", tempVis[s.temp], s.normValue, s.value, s.name)
+	vis, ok := tempVis[s.temp]
+	if !ok {
+		// Temps added by the shelf layout are shown by their initial.
+		vis = fmt.Sprintf("[%.1v ]", s.temp)
+	}
+	return fmt.Sprintf("%v %4.3f : %-6.3f : %v\n", vis, s.normValue, s.value, s.name)
 }
 
 // Holds the data required to render the screen
 type state struct {
 	orders  map[uuid.UUID]*orderState
 	shelves map[string]*ShelfState
-	ps      *pubsub.PubSub
+	layout  common.ShelfLayout
+	ps      common.PubsubInterface
+	// Holds the requested state shown on the status line.
+	controller *userrequests.Controller
 	// Diagnostic messages to be displayed.
 	diags []common.DiagEvent
 }
 
-func newDisplayState(ps *pubsub.PubSub) *state {
+func newDisplayState(ps common.PubsubInterface, layout common.ShelfLayout, controller *userrequests.Controller) *state {
 	orders := map[uuid.UUID]*orderState{}
-	shelves := map[string]*ShelfState{
-		"frozen":   NewShelfState(ps),
-		"cold":     NewShelfState(ps),
-		"hot":      NewShelfState(ps),
-		"overflow": NewShelfState(ps),
+	shelves := map[string]*ShelfState{}
+	for _, shelfName := range layout.ShelfNames() {
+		shelves[shelfName] = NewShelfState(ps)
 	}
-	return &state{orders: orders, shelves: shelves, ps: ps}
+	return &state{orders: orders, shelves: shelves, layout: layout, ps: ps, controller: controller}
 }
 
 func (s *state) update(e *common.ValueEvent) {
@@ -139,17 +145,22 @@ func (s state) render() {
 
 	tm.Clear()
 
-	// One display box per shelf.
-	boxWidth := screenWidth / 4
-	boxes := [4]*tm.Box{}
+	// One display box per shelf, overflow last.
+	shelfNames := s.layout.ShelfNames()
+	boxWidth := screenWidth / len(shelfNames)
+	boxes := make([]*tm.Box, len(shelfNames))
 
-	for column, shelfName := range []string{"frozen", "cold", "hot", "overflow"} {
-		var capacity int
-		if shelfName == "overflow" {
-			capacity = 20
-		} else {
-			capacity = 15
+	// The status line and diagnostics go below the primary shelves, next to the overflow box.
+	var maxPrimaryCapacity int
+	for _, shelf := range s.layout.Shelves {
+		if shelf.Capacity > maxPrimaryCapacity {
+			maxPrimaryCapacity = shelf.Capacity
 		}
+	}
+	statusLineRow := maxPrimaryCapacity + 4
+
+	for column, shelfName := range shelfNames {
+		capacity, _ := s.layout.Capacity(shelfName)
 
 		shelf := s.shelves[shelfName]
 
@@ -170,42 +181,43 @@ func (s state) render() {
 		_, _ = tm.Print(tm.MoveTo(box.String(), column*boxWidth+1, 1))
 	}
 	// Render the status line below the shelf boxes
-	tm.MoveCursor(1, 19)
-	_, _ = tm.Printf("%v\r\n", statusLine())
+	tm.MoveCursor(1, statusLineRow)
+	_, _ = tm.Printf("%v\r\n", statusLine(s.controller.State()))
 
 	// Render diagnostics box below the status line
-	diagBox := tm.NewBox(3*boxWidth, diagBoxHeight, 0)
+	diagBox := tm.NewBox(len(s.layout.Shelves)*boxWidth, diagBoxHeight, 0)
 	_, _ = fmt.Fprintf(diagBox, "%v\n", "Diagnostics")
 	for _, diag := range s.diags {
 		_, _ = fmt.Fprintf(diagBox, "%v\n", diag.String())
 	}
-	_, _ = tm.Print(tm.MoveTo(diagBox.String(), 1, 20))
+	_, _ = tm.Print(tm.MoveTo(diagBox.String(), 1, statusLineRow+1))
 
 	// Update the screen
 	tm.Flush()
 }
 
-func statusLine() (line string) {
-	if userrequests.RequestedState.PickUpPaused {
+func statusLine(state userrequests.State) (line string) {
+	if state.PickupPaused {
 		line += "[P] Toggle Pickup (Paused)  | "
 	} else {
 		line += "[P] Toggle Pickup (Running) | "
 	}
-	if userrequests.RequestedState.IncomingOrdersPaused {
+	if state.IncomingOrdersPaused {
 		line += "[I] Toggle Incoming Stream (Paused)  | "
 	} else {
 		line += "[I] Toggle Incoming Stream (Running) | "
 	}
-	line += "[Q] Quit"
+	line += fmt.Sprintf("Arrival Rate %v/s | [Q] Quit", state.ArrivalRate)
 	return
 }
 
-func Run(ps *pubsub.PubSub) {
-	go userrequests.Run(ps)
-
+// Run refreshes the screen until ctx is done.
+func Run(ctx context.Context, ps common.PubsubInterface, clock common.Clock, layout common.ShelfLayout,
+	controller *userrequests.Controller) {
 	valueCh := ps.Sub(common.ValueTopic)
 	pickupCh := ps.Sub(common.PickupTopic)
-	expiredCh := ps.Sub(common.ExpiredTopic)
+	// Evicted orders are only reported on the waste topic.
+	expiredCh := ps.Sub(common.ExpiredTopic, common.WasteTopic)
 	userRequestCh := ps.Sub(common.UserRequestTopic)
 	diagCh := ps.Sub(common.DiagTopic)
 
@@ -216,8 +228,10 @@ func Run(ps *pubsub.PubSub) {
 
 	// The spec called for updating the screen every time an order is added and moved, but that causes
 	// overloading the display.  Instead, the screen is refreshed once a second.
-	tickCh := time.Tick(common.Seconds(1))
-	s := newDisplayState(ps)
+	ticker := clock.NewTicker(common.Seconds(1))
+	defer ticker.Stop()
+	tickCh := ticker.C()
+	s := newDisplayState(ps, layout, controller)
 	for {
 		select {
 		case msg := <-valueCh:
@@ -238,14 +252,19 @@ func Run(ps *pubsub.PubSub) {
 				s.remove(e.Order.ID)
 			}
 		case msg := <-expiredCh:
-			e, ok := msg.(*common.ExpiredEvent)
-			if !ok {
+			var orderID uuid.UUID
+			switch e := msg.(type) {
+			case *common.ExpiredEvent:
+				orderID = e.Order.ID
+			case *common.WasteEvent:
+				orderID = e.Order.ID
+			default:
 				common.Diag(s.ps, serviceName, common.Error, common.CoerceErrorMessage(msg, e), nil)
 				continue
 			}
 			// May have been picked up
-			if s.orders[e.Order.ID] != nil {
-				s.remove(e.Order.ID)
+			if s.orders[orderID] != nil {
+				s.remove(orderID)
 			}
 		case msg := <-diagCh:
 			e, ok := msg.(*common.DiagEvent)
@@ -263,6 +282,8 @@ func Run(ps *pubsub.PubSub) {
 		case <-userRequestCh:
 			// Make the screen responsive to keyboard events
 			s.render()
+		case <-ctx.Done():
+			return
 		}
 	}
 }
@@ -1,12 +1,22 @@
 package ui
 
 import (
-	"github.com/cskr/pubsub"
+	"context"
+	"stream-first/common"
 	"stream-first/ui/screen"
 	"stream-first/ui/userrequests"
+	"sync"
 )
 
-func Run(ps *pubsub.PubSub) {
-	go userrequests.Run(ps)
-	screen.Run(ps)
+// Run shows the shelves and reads key presses until ctx is done.  It returns once the terminal is restored.
+func Run(ctx context.Context, ps common.PubsubInterface, clock common.Clock, layout common.ShelfLayout,
+	controller *userrequests.Controller) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		userrequests.Run(ctx, ps, controller)
+	}()
+	screen.Run(ctx, ps, clock, layout, controller)
+	wg.Wait()
 }
@@ -0,0 +1,106 @@
+package userrequests
+
+import (
+	"stream-first/common"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// State is what the user asked for so far.
+type State struct {
+	PickupPaused         bool    `json:"pickupPaused"`
+	IncomingOrdersPaused bool    `json:"incomingOrdersPaused"`
+	ArrivalRate          float64 `json:"arrivalRate"`
+}
+
+// Controller turns user requests, from the keyboard or the control API, into user request events.  It is the one
+// place the requested state is kept, for the status line and the control API to read.  Controller is safe for
+// concurrent use.
+type Controller struct {
+	ps    common.PubsubInterface
+	clock common.Clock
+
+	mu    sync.Mutex
+	state State
+}
+
+// NewController returns a controller with nothing paused, and orders arriving at arrivalRate per second.
+func NewController(ps common.PubsubInterface, clock common.Clock, arrivalRate float64) *Controller {
+	return &Controller{ps: ps, clock: clock, state: State{ArrivalRate: arrivalRate}}
+}
+
+// State returns the requested state.
+func (c *Controller) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Request makes one of the pause, resume or quit requests, and returns the new state.
+func (c *Controller) Request(request string) (state State, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.request(request)
+}
+
+// Make a request with c.mu held.
+func (c *Controller) request(request string) (state State, err error) {
+	switch request {
+	case PausePickup, ResumePickup:
+		c.state.PickupPaused = request == PausePickup
+	case PauseIncomingOrders, ResumeIncomingOrders:
+		c.state.IncomingOrdersPaused = request == PauseIncomingOrders
+	case QuitRequest:
+	default:
+		return c.state, errors.Errorf("unknown request %q", request)
+	}
+	// Publishing under the lock keeps the events in the same order as the state changes.
+	c.ps.Pub(&common.UserRequestEvent{Dt: c.clock.Now(), Request: request}, common.UserRequestTopic)
+	return c.state, nil
+}
+
+// TogglePickup pauses pickups if they are running, and resumes them otherwise.
+func (c *Controller) TogglePickup() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	request := PausePickup
+	if c.state.PickupPaused {
+		request = ResumePickup
+	}
+	state, _ := c.request(request)
+	return state
+}
+
+// ToggleIncomingOrders pauses incoming orders if they are arriving, and resumes them otherwise.
+func (c *Controller) ToggleIncomingOrders() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	request := PauseIncomingOrders
+	if c.state.IncomingOrdersPaused {
+		request = ResumeIncomingOrders
+	}
+	state, _ := c.request(request)
+	return state
+}
+
+// SetArrivalRate changes the mean number of new orders per second, and returns the new state.
+func (c *Controller) SetArrivalRate(rate float64) (state State, err error) {
+	if rate <= 0 {
+		return c.State(), errors.Errorf("arrival rate must be positive, got %v", rate)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.ArrivalRate = rate
+	c.ps.Pub(&common.UserRequestEvent{Dt: c.clock.Now(), Request: SetArrivalRate, Rate: rate}, common.UserRequestTopic)
+	return c.state, nil
+}
+
+// InjectOrder publishes a single new order, ahead of the order source and regardless of it being paused.  The order
+// is given a new ID, and returned.
+func (c *Controller) InjectOrder(order common.Order) common.Order {
+	order.ID = uuid.New()
+	c.ps.Pub(&common.NewOrderEvent{Dt: c.clock.Now(), Order: order}, common.NewOrderTopic)
+	return order
+}
@@ -0,0 +1,90 @@
+package userrequests_test
+
+import (
+	"stream-first/common"
+	"stream-first/mocks"
+	"stream-first/ui/userrequests"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestController(t *testing.T) {
+	t.Run("Requests change the state and are published", func(t *testing.T) {
+		ps := &mocks.MockPubsub{}
+		ps.On("Pub", mock.Anything, mock.Anything)
+		clock := common.NewSimClock(time.Now())
+		c := userrequests.NewController(ps, clock, 3.25)
+		assert.Equal(t, userrequests.State{ArrivalRate: 3.25}, c.State())
+
+		state, err := c.Request(userrequests.PausePickup)
+		require.NoError(t, err)
+		assert.Equal(t, userrequests.State{PickupPaused: true, ArrivalRate: 3.25}, state)
+		assert.Equal(t, userrequests.State{PickupPaused: true, IncomingOrdersPaused: true, ArrivalRate: 3.25},
+			c.ToggleIncomingOrders())
+		assert.Equal(t, userrequests.State{IncomingOrdersPaused: true, ArrivalRate: 3.25}, c.TogglePickup())
+		_, err = c.Request(userrequests.QuitRequest)
+		require.NoError(t, err)
+
+		for _, request := range []string{userrequests.PausePickup, userrequests.PauseIncomingOrders,
+			userrequests.ResumePickup, userrequests.QuitRequest} {
+			ps.AssertCalled(t, "Pub", &common.UserRequestEvent{Dt: clock.Now(), Request: request},
+				[]string{common.UserRequestTopic})
+		}
+	})
+	t.Run("Concurrent toggles each flip the state", func(t *testing.T) {
+		ps := &mocks.MockPubsub{}
+		ps.On("Pub", mock.Anything, mock.Anything)
+		c := userrequests.NewController(ps, common.NewSimClock(time.Now()), 3.25)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				c.TogglePickup()
+			}()
+			go func() {
+				defer wg.Done()
+				c.ToggleIncomingOrders()
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, userrequests.State{ArrivalRate: 3.25}, c.State())
+	})
+	t.Run("Unknown requests and non-positive rates are rejected without publishing", func(t *testing.T) {
+		ps := &mocks.MockPubsub{}
+		c := userrequests.NewController(ps, common.NewSimClock(time.Now()), 3.25)
+		_, err := c.Request("dance")
+		assert.Error(t, err)
+		_, err = c.SetArrivalRate(0)
+		assert.Error(t, err)
+		assert.Equal(t, userrequests.State{ArrivalRate: 3.25}, c.State())
+		ps.AssertNotCalled(t, "Pub", mock.Anything, mock.Anything)
+	})
+	t.Run("Arrival rate changes are published with the new rate", func(t *testing.T) {
+		ps := &mocks.MockPubsub{}
+		ps.On("Pub", mock.Anything, mock.Anything)
+		clock := common.NewSimClock(time.Now())
+		c := userrequests.NewController(ps, clock, 3.25)
+		state, err := c.SetArrivalRate(10)
+		require.NoError(t, err)
+		assert.Equal(t, 10.0, state.ArrivalRate)
+		ps.AssertCalled(t, "Pub",
+			&common.UserRequestEvent{Dt: clock.Now(), Request: userrequests.SetArrivalRate, Rate: 10},
+			[]string{common.UserRequestTopic})
+	})
+	t.Run("Injected orders are published with a new ID", func(t *testing.T) {
+		ps := &mocks.MockPubsub{}
+		ps.On("Pub", mock.Anything, mock.Anything)
+		clock := common.NewSimClock(time.Now())
+		c := userrequests.NewController(ps, clock, 3.25)
+		order := c.InjectOrder(common.Order{Name: "Pizza", Temp: "hot", ShelfLife: 300, DecayRate: 0.45})
+		assert.NotEqual(t, common.Order{}.ID, order.ID)
+		ps.AssertCalled(t, "Pub", &common.NewOrderEvent{Dt: clock.Now(), Order: order}, []string{common.NewOrderTopic})
+	})
+}
@@ -2,12 +2,13 @@ package userrequests
 
 import (
 	"bufio"
-	"fmt"
-	"github.com/cskr/pubsub"
+	"context"
 	"golang.org/x/crypto/ssh/terminal"
 	"os"
 	"stream-first/common"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 // This package captures keyboard events, and generates corresponding user request events.
@@ -15,13 +16,15 @@ const (
 	serviceName = "UI/UserRequests"
 )
 
-// User request events
+// User requests, see common.UserRequestEvent
 const (
 	QuitRequest          = "quit"
 	PausePickup          = "pausePickup"
 	ResumePickup         = "resumePickup"
 	PauseIncomingOrders  = "pauseIncomingOrders"
 	ResumeIncomingOrders = "resumeIncomingOrders"
+	// Comes with the new rate.
+	SetArrivalRate = "setArrivalRate"
 )
 
 // Handled key presses
@@ -31,54 +34,57 @@ var (
 	toggleIncomingRunes = map[rune]bool{'i': true, 'I': true}
 )
 
-var RequestedState = struct {
-	PickUpPaused         bool
-	IncomingOrdersPaused bool
-}{}
-
-func Run(ps *pubsub.PubSub) {
+// Run reads key presses, and passes the corresponding requests to controller, until ctx is done.  The terminal is in
+// raw mode meanwhile, and restored before Run returns.
+func Run(ctx context.Context, ps common.PubsubInterface, controller *Controller) {
 	// Allow time for other components to subscribe before starting to publish.
 	time.Sleep(common.Seconds(common.SchedulerDelay))
 	common.Diag(ps, serviceName, common.Info, "Service started.", nil)
 
 	// Set terminal to raw mode
-	oldState, err := terminal.MakeRaw(int(os.Stdin.Fd()))
+	fd := int(os.Stdin.Fd())
+	oldState, err := terminal.MakeRaw(fd)
 	if err != nil {
-		fmt.Printf("error: %+v\n", err)
+		common.Diag(ps, serviceName, common.Error, "", errors.Wrap(err, "keyboard"))
+	} else {
+		defer func() { _ = terminal.Restore(fd, oldState) }()
 	}
 
-	// restore appears not to work on linux, `stty echo cooked` is required after exiting the program.
-	defer func() { _ = terminal.Restore(0, oldState) }()
-
-	reader := bufio.NewReader(os.Stdin)
-
-	for {
-		r, _, err := reader.ReadRune()
-		if err != nil {
-			panic(err)
-		}
-		var userRequest string
-		if quitRunes[r] {
-			userRequest = QuitRequest
-		}
-		if togglePickupRunes[r] {
-			if RequestedState.PickUpPaused {
-				userRequest = ResumePickup
-			} else {
-				userRequest = PausePickup
+	// Reading can't be interrupted, so the reader is left blocked on stdin once ctx is done.
+	runeCh, errCh := make(chan rune), make(chan error, 1)
+	go func() {
+		reader := bufio.NewReader(os.Stdin)
+		for {
+			r, _, err := reader.ReadRune()
+			if err != nil {
+				errCh <- err
+				return
 			}
-			RequestedState.PickUpPaused = !RequestedState.PickUpPaused
-		}
-		if toggleIncomingRunes[r] {
-			if RequestedState.IncomingOrdersPaused {
-				userRequest = ResumeIncomingOrders
-			} else {
-				userRequest = PauseIncomingOrders
+			select {
+			case runeCh <- r:
+			case <-ctx.Done():
+				return
 			}
-			RequestedState.IncomingOrdersPaused = !RequestedState.IncomingOrdersPaused
 		}
-		if userRequest != "" {
-			ps.Pub(userRequest, common.UserRequestTopic)
+	}()
+
+	for {
+		select {
+		case r := <-runeCh:
+			switch {
+			case quitRunes[r]:
+				_, _ = controller.Request(QuitRequest)
+			case togglePickupRunes[r]:
+				controller.TogglePickup()
+			case toggleIncomingRunes[r]:
+				controller.ToggleIncomingOrders()
+			}
+		case err := <-errCh:
+			// Without a keyboard, the run can still be controlled through the control API.
+			common.Diag(ps, serviceName, common.Error, "", errors.Wrap(err, "keyboard"))
+			errCh = nil
+		case <-ctx.Done():
+			return
 		}
 	}
 }